@@ -0,0 +1,324 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AppRoleResource  = "vaultapproles"
+	AppRoleFinalizer = "vault.boostport.com/approle"
+)
+
+// VaultAppRole declares an AppRole that the leader creates and keeps in sync in Vault.
+type VaultAppRole struct {
+	ApiVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Spec       VaultAppRoleSpec       `json:"spec"`
+	Status     *VaultAppRoleStatus    `json:"status,omitempty"`
+}
+
+type VaultAppRoleSpec struct {
+	// RoleName is the name of the AppRole in Vault. By default, the name of the VaultAppRole is used.
+	RoleName        string   `json:"roleName,omitempty"`
+	MountPath       string   `json:"mountPath,omitempty"`
+	Policies        []string `json:"policies,omitempty"`
+	Period          string   `json:"period,omitempty"`
+	TokenTTL        string   `json:"tokenTTL,omitempty"`
+	TokenMaxTTL     string   `json:"tokenMaxTTL,omitempty"`
+	TokenNumUses    int      `json:"tokenNumUses,omitempty"`
+	SecretIdTTL     string   `json:"secretIdTTL,omitempty"`
+	SecretIdNumUses int      `json:"secretIdNumUses,omitempty"`
+	BoundCIDRList   []string `json:"boundCIDRList,omitempty"`
+}
+
+type VaultAppRoleStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// RoleName and MountPath are where the AppRole was last written to in Vault, so that it can be deleted if
+	// spec.roleName or spec.mountPath change.
+	RoleName  string `json:"roleName,omitempty"`
+	MountPath string `json:"mountPath,omitempty"`
+
+	Conditions []Condition `json:"conditions,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
+}
+
+type vaultAppRoleList struct {
+	Items []VaultAppRole `json:"items"`
+}
+
+func (a VaultAppRole) Name() string {
+	name, _ := a.Metadata["name"].(string)
+	return name
+}
+
+// GetRoleName returns the name of the AppRole in Vault.
+func (a VaultAppRole) GetRoleName() string {
+
+	if a.Spec.RoleName != "" {
+		return a.Spec.RoleName
+	}
+
+	return a.Name()
+}
+
+func (a VaultAppRole) Generation() int64 {
+	generation, _ := a.Metadata["generation"].(float64)
+	return int64(generation)
+}
+
+// IsDeleted returns whether the VaultAppRole is waiting for its finalizer to be removed.
+func (a VaultAppRole) IsDeleted() bool {
+	_, ok := a.Metadata["deletionTimestamp"]
+	return ok
+}
+
+func (a VaultAppRole) HasFinalizer() bool {
+
+	finalizers, _ := a.Metadata["finalizers"].([]interface{})
+
+	for _, finalizer := range finalizers {
+		if finalizer == AppRoleFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *VaultAppRole) AddFinalizer() {
+
+	finalizers, _ := a.Metadata["finalizers"].([]interface{})
+
+	a.Metadata["finalizers"] = append(finalizers, AppRoleFinalizer)
+}
+
+func (a *VaultAppRole) RemoveFinalizer() {
+
+	finalizers, _ := a.Metadata["finalizers"].([]interface{})
+
+	remaining := []interface{}{}
+
+	for _, finalizer := range finalizers {
+		if finalizer != AppRoleFinalizer {
+			remaining = append(remaining, finalizer)
+		}
+	}
+
+	a.Metadata["finalizers"] = remaining
+}
+
+// SetCondition replaces the condition of the same type, keeping its transition time if the status did not change.
+func (a *VaultAppRole) SetCondition(condition Condition) {
+
+	if a.Status == nil {
+		a.Status = &VaultAppRoleStatus{}
+	}
+
+	conditions := []Condition{}
+
+	for _, c := range a.Status.Conditions {
+
+		if c.Type != condition.Type {
+			conditions = append(conditions, c)
+			continue
+		}
+
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+
+	if condition.LastTransitionTime == "" {
+		condition.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	a.Status.Conditions = append(conditions, condition)
+}
+
+// GetVaultAppRoles lists the VaultAppRoles in a namespace, sorted by name.
+func (k *Kube) GetVaultAppRoles(namespace string) ([]VaultAppRole, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var list vaultAppRoleList
+
+	err := k.client.ThirdPartyResources(APIGroup, APIVersion).List(ctx, AppRoleResource, namespace, &list)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list vault approles")
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name() < list.Items[j].Name()
+	})
+
+	return list.Items, nil
+}
+
+// UpdateVaultAppRole writes the metadata and status of a VaultAppRole.
+func (k *Kube) UpdateVaultAppRole(namespace string, appRole VaultAppRole) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var updated VaultAppRole
+
+	err := k.client.ThirdPartyResources(APIGroup, APIVersion).Update(ctx, AppRoleResource, namespace, appRole.Name(), &appRole, &updated)
+
+	if err != nil {
+		return errors.Wrapf(err, "could not update vault approle %s", appRole.Name())
+	}
+
+	return nil
+}
+
+// parameters converts the spec into the parameters of auth/<mount>/role/<name>. Every parameter is always set, so
+// that removing a field from the spec resets it in Vault.
+func (s VaultAppRoleSpec) parameters() (map[string]interface{}, error) {
+
+	durations := map[string]string{
+		"period":        s.Period,
+		"token_ttl":     s.TokenTTL,
+		"token_max_ttl": s.TokenMaxTTL,
+		"secret_id_ttl": s.SecretIdTTL,
+	}
+
+	parameters := map[string]interface{}{
+		"policies":           strings.Join(s.Policies, ","),
+		"token_num_uses":     s.TokenNumUses,
+		"secret_id_num_uses": s.SecretIdNumUses,
+		"bound_cidr_list":    strings.Join(s.BoundCIDRList, ","),
+	}
+
+	for parameter, duration := range durations {
+
+		if duration == "" {
+			parameters[parameter] = 0
+			continue
+		}
+
+		d, err := time.ParseDuration(duration)
+
+		if err != nil {
+			return nil, errors.Errorf("%s (%s) is not a valid duration", parameter, duration)
+		}
+
+		parameters[parameter] = int(d.Seconds())
+	}
+
+	return parameters, nil
+}
+
+// Warnings returns the ways the AppRole does not follow the best practices for Kubernetes-Vault.
+func (s VaultAppRoleSpec) Warnings() []string {
+
+	warnings := []string{}
+
+	if s.Period == "" {
+		warnings = append(warnings, "spec.period is not set, so tokens are not periodic and cannot be renewed indefinitely")
+	}
+
+	if s.SecretIdNumUses != 1 {
+		warnings = append(warnings, "spec.secretIdNumUses is not 1, so a secret_id can be used more than once")
+	}
+
+	return warnings
+}
+
+// normalizeRoleParameter converts the value of a role parameter as sent or as read from Vault into Vault's canonical
+// form, so that the values Vault rewrites when it stores a role do not show up as drift. Vault adds the default policy,
+// lower cases policies, may read durations back as duration strings, masks the addresses in CIDRs and may read lists
+// back as JSON arrays or comma separated strings, whose order does not matter.
+func normalizeRoleParameter(parameter string, value interface{}) string {
+
+	switch parameter {
+	case "policies":
+
+		policies := map[string]bool{"default": true}
+
+		for _, policy := range listItems(value) {
+			policies[strings.ToLower(policy)] = true
+		}
+
+		if policies["root"] {
+			delete(policies, "default")
+		}
+
+		items := []string{}
+
+		for policy := range policies {
+			items = append(items, policy)
+		}
+
+		return joinSorted(items)
+
+	case "bound_cidr_list":
+
+		items := []string{}
+
+		for _, cidr := range listItems(value) {
+
+			if _, network, err := net.ParseCIDR(cidr); err == nil {
+				cidr = network.String()
+			}
+
+			items = append(items, cidr)
+		}
+
+		return joinSorted(items)
+
+	case "period", "token_ttl", "token_max_ttl", "secret_id_ttl":
+
+		if s, ok := value.(string); ok {
+			if d, err := time.ParseDuration(s); err == nil {
+				return fmt.Sprint(int(d.Seconds()))
+			}
+		}
+	}
+
+	switch value.(type) {
+	case []interface{}, []string, string, nil:
+		return joinSorted(listItems(value))
+	}
+
+	return fmt.Sprint(value)
+}
+
+// listItems returns the items of a list parameter, which may be read back as a JSON array or a comma separated string.
+func listItems(value interface{}) []string {
+
+	items := []string{}
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+	case []string:
+		items = append(items, v...)
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items
+}
+
+// joinSorted sorts the items of a list parameter and joins them into a comparable string.
+func joinSorted(items []string) string {
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeRoleParameter(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		parameter string
+		sent      interface{}
+		read      interface{}
+		drift     bool
+	}{
+		{
+			name:      "policies with the default policy added by Vault",
+			parameter: "policies",
+			sent:      "sample-app,Metrics",
+			read:      []interface{}{"default", "metrics", "sample-app"},
+			drift:     false,
+		},
+		{
+			name:      "policies read back as a comma separated string",
+			parameter: "policies",
+			sent:      "sample-app",
+			read:      "default, sample-app",
+			drift:     false,
+		},
+		{
+			name:      "root policy without the default policy",
+			parameter: "policies",
+			sent:      "root",
+			read:      []interface{}{"root"},
+			drift:     false,
+		},
+		{
+			name:      "changed policies",
+			parameter: "policies",
+			sent:      "sample-app,metrics",
+			read:      []interface{}{"default", "sample-app"},
+			drift:     true,
+		},
+		{
+			name:      "no policies",
+			parameter: "policies",
+			sent:      "",
+			read:      []interface{}{"default"},
+			drift:     false,
+		},
+		{
+			name:      "CIDRs masked by Vault",
+			parameter: "bound_cidr_list",
+			sent:      "10.2.3.4/16,192.168.0.0/24",
+			read:      []interface{}{"192.168.0.0/24", "10.2.0.0/16"},
+			drift:     false,
+		},
+		{
+			name:      "changed CIDRs",
+			parameter: "bound_cidr_list",
+			sent:      "10.2.0.0/16",
+			read:      "10.3.0.0/16",
+			drift:     true,
+		},
+		{
+			name:      "no CIDRs",
+			parameter: "bound_cidr_list",
+			sent:      "",
+			read:      nil,
+			drift:     false,
+		},
+		{
+			name:      "duration read back as seconds",
+			parameter: "token_ttl",
+			sent:      3600,
+			read:      json.Number("3600"),
+			drift:     false,
+		},
+		{
+			name:      "duration read back as a duration string",
+			parameter: "period",
+			sent:      86400,
+			read:      "24h0m0s",
+			drift:     false,
+		},
+		{
+			name:      "changed duration",
+			parameter: "secret_id_ttl",
+			sent:      600,
+			read:      json.Number("60"),
+			drift:     true,
+		},
+		{
+			name:      "number of uses",
+			parameter: "secret_id_num_uses",
+			sent:      1,
+			read:      json.Number("1"),
+			drift:     false,
+		},
+		{
+			name:      "changed number of uses",
+			parameter: "token_num_uses",
+			sent:      0,
+			read:      json.Number("5"),
+			drift:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			sent := normalizeRoleParameter(test.parameter, test.sent)
+			read := normalizeRoleParameter(test.parameter, test.read)
+
+			if drift := sent != read; drift != test.drift {
+				t.Errorf("expected drift to be %t, got %t: sent %q, read %q", test.drift, drift, sent, read)
+			}
+		})
+	}
+}
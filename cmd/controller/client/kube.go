@@ -1,35 +1,128 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Boostport/kubernetes-vault/common"
 	"github.com/cenkalti/backoff"
 	"github.com/ericchiang/k8s"
 	"github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	RoleAnnotation                = "pod.boostport.com/vault-approle"
+	AppRolesAnnotation            = "pod.boostport.com/vault-approles"
+	AppRoleMountPathAnnotation    = "pod.boostport.com/vault-approle-mount-path"
+	TokenRoleAnnotation           = "pod.boostport.com/vault-token-role"
+	SecretsAnnotation             = "pod.boostport.com/vault-secrets"
 	InitContainerAnnotation       = "pod.boostport.com/vault-init-container"
+	DeliveryAnnotation            = "pod.boostport.com/vault-delivery"
 	InitContainerStatusAnnotation = "pod.beta.kubernetes.io/init-container-statuses"
+
+	RoleIDEnv = "VAULT_ROLE_ID"
+
+	// PushDelivery is the default: the controller pushes the wrapped secret to the init container.
+	PushDelivery = "push"
+
+	// PullDelivery means the init container requests the wrapped secret from the controller using its service
+	// account token.
+	PullDelivery = "pull"
+
+	// SecretDelivery means the controller writes the wrapped secret to a Secret owned by the pod, which the init
+	// container reads using its service account.
+	SecretDelivery = "secret"
+
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+	podNameExtra                 = "authentication.kubernetes.io/pod-name"
+	podUIDExtra                  = "authentication.kubernetes.io/pod-uid"
 )
 
 type Kube struct {
 	client              *k8s.Client
 	watchNamespaceRegex *regexp.Regexp
+	allowedImages       map[string]bool
+	allowedDigests      map[string]bool
 	logger              *logrus.Logger
+
+	// rejectedLock guards rejected, which holds the init containers an event was already recorded for, so that a
+	// rejected init container only gets one event.
+	rejectedLock sync.Mutex
+	rejected     map[string]bool
 }
 
 type Pod struct {
+	Name             string
+	Namespace        string
+	UID              string
+	ServiceAccount   string
+	Labels           map[string]string
+	Role             string
+	AppRoleMountPath string
+	TokenRole        string
+	AppRoles         []AppRoleRequest
+	Secrets          []SecretRequest
+	Ip               string
+	Port             int
+
+	// Delivery is PushDelivery, PullDelivery or SecretDelivery.
+	Delivery string
+
+	// InitContainerID is the id of the running init container. It changes every time the init container is
+	// restarted, which is the case for native sidecars (init containers with restartPolicy: Always).
+	InitContainerID string
+
+	// RoleIDSource is where the init container gets its VAULT_ROLE_ID environment variable from, if it is set.
+	RoleIDSource *EnvSource
+}
+
+// Key uniquely identifies a pod across namespaces.
+func (p Pod) Key() string {
+	return p.Namespace + "/" + p.Name
+}
+
+// AppRoleRequest is one of several AppRoles a pod wants a secret_id for. Name identifies the credentials in the pod.
+type AppRoleRequest struct {
 	Name string
 	Role string
-	Ip   string
-	Port int
+
+	// RoleIDSource is where the init container gets the VAULT_ROLE_ID_<NAME> environment variable for this AppRole
+	// from, if it is set.
+	RoleIDSource *EnvSource
+}
+
+// EnvSource is where the value of a container's environment variable comes from: either the value itself or a key in
+// a Secret or ConfigMap in the pod's namespace.
+type EnvSource struct {
+	Value         string
+	SecretName    string
+	ConfigMapName string
+	Key           string
+}
+
+// TokenIdentity is the pod a service account token was issued to, as reported by the TokenReview API.
+type TokenIdentity struct {
+	Namespace      string
+	ServiceAccount string
+	PodName        string
+	PodUID         string
+}
+
+// SecretRequest is an additional Vault path a pod wants delivered as a wrapped response.
+type SecretRequest struct {
+	Name string                 `json:"name"`
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data"`
 }
 
 type InitContainerStatus struct {
@@ -49,19 +142,25 @@ func (k *Kube) GetPods() ([]Pod, error) {
 		return p, errors.Wrap(err, "could not list pods")
 	}
 
+	current := map[string]bool{}
+
 	for _, pod := range pods.Items {
 
 		if !k.isInWatchedNamespace(*pod.Metadata.Namespace) {
 			continue
 		}
 
-		convertedPod, err := convertToPod(pod)
+		current[pod.Metadata.GetUid()] = true
+
+		convertedPod, err := k.convertToPod(pod)
 
 		if err == nil {
 			p = append(p, convertedPod)
 		}
 	}
 
+	k.forgetRejected(current)
+
 	return p, nil
 }
 
@@ -123,7 +222,7 @@ func (k *Kube) watch(events chan<- Pod, stop <-chan struct{}) {
 
 				if *event.Type == k8s.EventAdded || *event.Type == k8s.EventModified {
 					if k.isInWatchedNamespace(*pod.Metadata.Namespace) {
-						convertedPod, err := convertToPod(pod)
+						convertedPod, err := k.convertToPod(pod)
 
 						if err == nil {
 							events <- convertedPod
@@ -144,10 +243,14 @@ func (k *Kube) watch(events chan<- Pod, stop <-chan struct{}) {
 	}
 }
 
-func convertToPod(pod *v1.Pod) (Pod, error) {
+func (k *Kube) convertToPod(pod *v1.Pod) (Pod, error) {
 
 	initContainerReady := false
+	initContainerID := ""
+	initContainerImageID := ""
 	role, hasRole := pod.Metadata.Annotations[RoleAnnotation]
+	tokenRole, hasTokenRole := pod.Metadata.Annotations[TokenRoleAnnotation]
+	appRolesAnnotation, hasAppRoles := pod.Metadata.Annotations[AppRolesAnnotation]
 	initContainerName, hasInitContainerName := pod.Metadata.Annotations[InitContainerAnnotation]
 	podStatus := pod.GetStatus()
 
@@ -159,24 +262,549 @@ func convertToPod(pod *v1.Pod) (Pod, error) {
 
 				if initContainerStatus.State != nil && initContainerStatus.State.Running != nil {
 					initContainerReady = true
+					initContainerID = initContainerStatus.GetContainerID()
+					initContainerImageID = initContainerStatus.GetImageID()
 					break
 				}
 			}
 		}
 	}
 
-	if hasRole && hasInitContainerName && podStatus != nil && initContainerReady {
+	if err := CheckRoleAnnotations(pod.Metadata.Annotations); err != nil {
+		return Pod{}, errors.Wrapf(err, "Pod (%s) has conflicting annotations", *pod.Metadata.Name)
+	}
+
+	appRoles, err := ParseAppRoleRequests(appRolesAnnotation)
+
+	if err != nil {
+		return Pod{}, errors.Wrapf(err, "Pod (%s) has an invalid %s annotation", *pod.Metadata.Name, AppRolesAnnotation)
+	}
+
+	secrets, err := ParseSecretRequests(pod.Metadata.Annotations[SecretsAnnotation])
+
+	if err != nil {
+		return Pod{}, errors.Wrapf(err, "Pod (%s) has an invalid %s annotation", *pod.Metadata.Name, SecretsAnnotation)
+	}
+
+	delivery, err := ParseDelivery(pod.Metadata.Annotations[DeliveryAnnotation])
+
+	if err != nil {
+		return Pod{}, errors.Wrapf(err, "Pod (%s) has an invalid %s annotation", *pod.Metadata.Name, DeliveryAnnotation)
+	}
+
+	var (
+		roleIDSource       *EnvSource
+		initContainerImage string
+	)
+
+	if pod.Spec != nil {
+
+		for _, container := range pod.Spec.InitContainers {
+
+			if container.GetName() == initContainerName {
+				roleIDSource = getEnvSource(container, RoleIDEnv)
+
+				for i, appRole := range appRoles {
+					appRoles[i].RoleIDSource = getEnvSource(container, common.AppRoleIDEnv(appRole.Name))
+				}
+
+				initContainerImage = container.GetImage()
+				break
+			}
+		}
+	}
+
+	if (hasRole || hasTokenRole || hasAppRoles) && hasInitContainerName && podStatus != nil && initContainerReady {
+
+		if err := k.checkInitContainerImage(initContainerImage, initContainerImageID); err != nil {
+			k.reject(pod, initContainerID, err)
+			return Pod{}, errors.Wrapf(err, "Pod (%s) is not allowed", *pod.Metadata.Name)
+		}
+
 		return Pod{
-			Name: *pod.Metadata.Name,
-			Role: role,
-			Ip:   *pod.Status.PodIP,
-			Port: common.InitContainerPort,
+			Name:             *pod.Metadata.Name,
+			Namespace:        pod.Metadata.GetNamespace(),
+			UID:              pod.Metadata.GetUid(),
+			ServiceAccount:   pod.GetSpec().GetServiceAccountName(),
+			Labels:           pod.Metadata.GetLabels(),
+			Role:             role,
+			AppRoleMountPath: pod.Metadata.Annotations[AppRoleMountPathAnnotation],
+			TokenRole:        tokenRole,
+			AppRoles:         appRoles,
+			Secrets:          secrets,
+			Ip:               *pod.Status.PodIP,
+			Port:             common.InitContainerPort,
+			Delivery:         delivery,
+			InitContainerID:  initContainerID,
+			RoleIDSource:     roleIDSource,
 		}, nil
 	}
 
 	return Pod{}, errors.Errorf("Pod (%s) is not ready yet", *pod.Metadata.Name)
 }
 
+// checkInitContainerImage checks the image of the init container and the digest it resolved to against the allowed
+// images and digests. If neither are configured, all images are allowed.
+func (k *Kube) checkInitContainerImage(image string, imageID string) error {
+
+	if len(k.allowedImages) > 0 && !k.allowedImages[imageRepository(image)] {
+		return errors.Errorf("init container image (%s) is not in the list of allowed images", image)
+	}
+
+	if len(k.allowedDigests) > 0 {
+
+		digest := imageDigest(imageID)
+
+		if digest == "" {
+			return errors.Errorf("the digest of the init container image (%s) is unknown", image)
+		}
+
+		if !k.allowedDigests[digest] {
+			return errors.Errorf("init container image digest (%s) is not in the list of allowed digests", digest)
+		}
+	}
+
+	return nil
+}
+
+// reject records a warning event for a pod whose init container is not allowed, once per init container.
+func (k *Kube) reject(pod *v1.Pod, initContainerID string, reason error) {
+
+	key := pod.Metadata.GetUid() + "/" + initContainerID
+
+	k.rejectedLock.Lock()
+	alreadyRejected := k.rejected[key]
+	k.rejected[key] = true
+	k.rejectedLock.Unlock()
+
+	if alreadyRejected {
+		return
+	}
+
+	k.logger.Errorf("Not pushing secrets to pod (%s): %s", pod.Metadata.GetName(), reason)
+
+	err := k.CreateEvent(Pod{
+		Name:      pod.Metadata.GetName(),
+		Namespace: pod.Metadata.GetNamespace(),
+		UID:       pod.Metadata.GetUid(),
+	}, "Warning", "InitContainerNotAllowed", fmt.Sprintf("No secrets were pushed: %s.", reason))
+
+	if err != nil {
+		k.logger.Errorf("Could not record rejection of pod (%s): %s", pod.Metadata.GetName(), err)
+	}
+}
+
+// forgetRejected removes the rejected init containers of pods that no longer exist.
+func (k *Kube) forgetRejected(podUIDs map[string]bool) {
+
+	k.rejectedLock.Lock()
+	defer k.rejectedLock.Unlock()
+
+	for key := range k.rejected {
+		if !podUIDs[strings.SplitN(key, "/", 2)[0]] {
+			delete(k.rejected, key)
+		}
+	}
+}
+
+// imageRepository returns the repository of an image without its tag, digest and the default docker.io registry, so
+// that boostport/kubernetes-vault-init:0.5.0 and docker.io/boostport/kubernetes-vault-init@sha256:... match.
+func imageRepository(image string) string {
+
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+
+	if i := strings.LastIndex(image, ":"); i != -1 && !strings.Contains(image[i:], "/") {
+		image = image[:i]
+	}
+
+	image = strings.TrimPrefix(image, "docker.io/")
+
+	return strings.TrimPrefix(image, "library/")
+}
+
+// imageDigest returns the digest from the image id reported in a container status. Depending on the container runtime,
+// this is docker-pullable://<repository>@sha256:..., <repository>@sha256:... or sha256:...
+func imageDigest(imageID string) string {
+
+	if i := strings.LastIndex(imageID, "@"); i != -1 {
+		return imageID[i+1:]
+	}
+
+	if strings.HasPrefix(imageID, "sha256:") {
+		return imageID
+	}
+
+	return ""
+}
+
+// getEnvSource returns where a container gets an environment variable from, or nil if the variable is not set using
+// a value, a Secret or a ConfigMap.
+func getEnvSource(container *v1.Container, name string) *EnvSource {
+
+	for _, env := range container.Env {
+
+		if env.GetName() != name {
+			continue
+		}
+
+		valueFrom := env.GetValueFrom()
+
+		switch {
+		case valueFrom == nil:
+			return &EnvSource{Value: env.GetValue()}
+
+		case valueFrom.SecretKeyRef != nil:
+			return &EnvSource{
+				SecretName: valueFrom.SecretKeyRef.GetLocalObjectReference().GetName(),
+				Key:        valueFrom.SecretKeyRef.GetKey(),
+			}
+
+		case valueFrom.ConfigMapKeyRef != nil:
+			return &EnvSource{
+				ConfigMapName: valueFrom.ConfigMapKeyRef.GetLocalObjectReference().GetName(),
+				Key:           valueFrom.ConfigMapKeyRef.GetKey(),
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// CheckRoleAnnotations checks that a pod does not combine the RoleAnnotation, TokenRoleAnnotation and
+// AppRolesAnnotation, which are mutually exclusive.
+func CheckRoleAnnotations(annotations map[string]string) error {
+
+	_, hasRole := annotations[RoleAnnotation]
+	_, hasTokenRole := annotations[TokenRoleAnnotation]
+	_, hasAppRoles := annotations[AppRolesAnnotation]
+
+	if hasRole && hasTokenRole {
+		return errors.Errorf("the %s and %s annotations cannot be used together", RoleAnnotation, TokenRoleAnnotation)
+	}
+
+	if hasAppRoles && (hasRole || hasTokenRole) {
+		return errors.Errorf("the %s annotation cannot be used together with the %s or %s annotations", AppRolesAnnotation, RoleAnnotation, TokenRoleAnnotation)
+	}
+
+	return nil
+}
+
+// ParseDelivery returns the delivery requested by the DeliveryAnnotation, which defaults to PushDelivery.
+func ParseDelivery(annotation string) (string, error) {
+
+	switch annotation {
+	case "":
+		return PushDelivery, nil
+	case PushDelivery, PullDelivery, SecretDelivery:
+		return annotation, nil
+	}
+
+	return "", errors.Errorf("%s is not %s, %s or %s", annotation, PushDelivery, PullDelivery, SecretDelivery)
+}
+
+// ParseAppRoleRequests parses the comma separated list of name=role pairs in the AppRolesAnnotation.
+func ParseAppRoleRequests(annotation string) ([]AppRoleRequest, error) {
+
+	appRoles := []AppRoleRequest{}
+
+	if annotation == "" {
+		return appRoles, nil
+	}
+
+	names := map[string]bool{}
+
+	for _, pair := range strings.Split(annotation, ",") {
+
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+
+		if len(parts) != 2 || parts[1] == "" {
+			return appRoles, errors.Errorf("expected name=role, got (%s)", pair)
+		}
+
+		if !common.ValidSecretName(parts[0]) {
+			return appRoles, errors.Errorf("AppRole name (%s) is invalid", parts[0])
+		}
+
+		if names[parts[0]] {
+			return appRoles, errors.Errorf("AppRole name (%s) is used more than once", parts[0])
+		}
+
+		names[parts[0]] = true
+
+		appRoles = append(appRoles, AppRoleRequest{
+			Name: parts[0],
+			Role: parts[1],
+		})
+	}
+
+	return appRoles, nil
+}
+
+// ParseSecretRequests parses the JSON list of secrets in the SecretsAnnotation.
+func ParseSecretRequests(annotation string) ([]SecretRequest, error) {
+
+	secrets := []SecretRequest{}
+
+	if annotation == "" {
+		return secrets, nil
+	}
+
+	if err := json.Unmarshal([]byte(annotation), &secrets); err != nil {
+		return secrets, errors.Wrap(err, "could not decode secrets")
+	}
+
+	names := map[string]bool{}
+
+	for _, secret := range secrets {
+
+		if !common.ValidSecretName(secret.Name) {
+			return secrets, errors.Errorf("secret name (%s) is invalid", secret.Name)
+		}
+
+		if names[secret.Name] {
+			return secrets, errors.Errorf("secret name (%s) is used more than once", secret.Name)
+		}
+
+		if secret.Path == "" {
+			return secrets, errors.Errorf("secret (%s) does not have a path", secret.Name)
+		}
+
+		names[secret.Name] = true
+	}
+
+	return secrets, nil
+}
+
+// GetPod gets a pod that is ready to receive a secret.
+func (k *Kube) GetPod(namespace string, name string) (Pod, error) {
+
+	if !k.isInWatchedNamespace(namespace) {
+		return Pod{}, errors.Errorf("namespace (%s) is not watched", namespace)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	pod, err := k.client.CoreV1().GetPod(ctx, name, namespace)
+
+	if err != nil {
+		return Pod{}, errors.Wrapf(err, "could not get pod %s", name)
+	}
+
+	return k.convertToPod(pod)
+}
+
+// tokenReview is a TokenReview of the authentication.k8s.io/v1 API. The generated types of the Kubernetes client
+// predate audiences, so the review is sent as JSON.
+type tokenReview struct {
+	ApiVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       tokenReviewSpec   `json:"spec"`
+	Status     tokenReviewStatus `json:"status"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool     `json:"authenticated"`
+	Audiences     []string `json:"audiences"`
+	Error         string   `json:"error"`
+	User          struct {
+		Username string              `json:"username"`
+		Extra    map[string][]string `json:"extra"`
+	} `json:"user"`
+}
+
+// ReviewToken validates a service account token issued for the audience using the TokenReview API and returns the pod
+// it was issued to. Legacy tokens that are not bound to a pod are rejected.
+func (k *Kube) ReviewToken(token string, audience string) (TokenIdentity, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(tokenReview{
+		ApiVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec: tokenReviewSpec{
+			Token:     token,
+			Audiences: []string{audience},
+		},
+	})
+
+	if err != nil {
+		return TokenIdentity{}, errors.Wrap(err, "could not encode token review")
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(k.client.Endpoint, "/")+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(body))
+
+	if err != nil {
+		return TokenIdentity{}, errors.Wrap(err, "could not create token review request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if k.client.SetHeaders != nil {
+		if err := k.client.SetHeaders(req.Header); err != nil {
+			return TokenIdentity{}, errors.Wrap(err, "could not set token review request headers")
+		}
+	}
+
+	httpClient := k.client.Client
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return TokenIdentity{}, errors.Wrap(err, "could not review token")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return TokenIdentity{}, errors.Errorf("could not review token: %s", resp.Status)
+	}
+
+	var review tokenReview
+
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return TokenIdentity{}, errors.Wrap(err, "could not decode token review")
+	}
+
+	status := review.Status
+
+	if !status.Authenticated {
+		return TokenIdentity{}, errors.Errorf("token is not valid: %s", status.Error)
+	}
+
+	audienceFound := false
+
+	for _, a := range status.Audiences {
+		if a == audience {
+			audienceFound = true
+			break
+		}
+	}
+
+	if !audienceFound {
+		return TokenIdentity{}, errors.Errorf("token was not issued for the audience (%s)", audience)
+	}
+
+	username := status.User.Username
+
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) {
+		return TokenIdentity{}, errors.Errorf("token does not belong to a service account: %s", username)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(username, serviceAccountUsernamePrefix), ":")
+
+	if len(parts) != 2 {
+		return TokenIdentity{}, errors.Errorf("invalid service account username: %s", username)
+	}
+
+	podName := status.User.Extra[podNameExtra]
+	podUID := status.User.Extra[podUIDExtra]
+
+	if len(podName) != 1 || len(podUID) != 1 {
+		return TokenIdentity{}, errors.New("token is not bound to a pod")
+	}
+
+	return TokenIdentity{
+		Namespace:      parts[0],
+		ServiceAccount: parts[1],
+		PodName:        podName[0],
+		PodUID:         podUID[0],
+	}, nil
+}
+
+// ResolveEnv returns the value of an environment variable, reading it from a Secret or ConfigMap if needed.
+func (k *Kube) ResolveEnv(namespace string, source EnvSource) (string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	switch {
+	case source.SecretName != "":
+		secret, err := k.client.CoreV1().GetSecret(ctx, source.SecretName, namespace)
+
+		if err != nil {
+			return "", errors.Wrapf(err, "could not get secret %s", source.SecretName)
+		}
+
+		value, ok := secret.Data[source.Key]
+
+		if !ok {
+			return "", errors.Errorf("secret %s does not have the key %s", source.SecretName, source.Key)
+		}
+
+		return string(value), nil
+
+	case source.ConfigMapName != "":
+		configMap, err := k.client.CoreV1().GetConfigMap(ctx, source.ConfigMapName, namespace)
+
+		if err != nil {
+			return "", errors.Wrapf(err, "could not get config map %s", source.ConfigMapName)
+		}
+
+		value, ok := configMap.Data[source.Key]
+
+		if !ok {
+			return "", errors.Errorf("config map %s does not have the key %s", source.ConfigMapName, source.Key)
+		}
+
+		return value, nil
+	}
+
+	return source.Value, nil
+}
+
+// CreateEvent records a Kubernetes event for a pod, so that problems show up in kubectl describe pod.
+func (k *Kube) CreateEvent(pod Pod, eventType string, reason string, message string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	seconds := time.Now().Unix()
+	count := int32(1)
+	now := &metav1.Time{Seconds: &seconds}
+
+	_, err := k.client.CoreV1().CreateEvent(ctx, &v1.Event{
+		Metadata: &metav1.ObjectMeta{
+			GenerateName: k8s.String(pod.Name + "."),
+			Namespace:    k8s.String(pod.Namespace),
+		},
+		InvolvedObject: &v1.ObjectReference{
+			Kind:       k8s.String("Pod"),
+			ApiVersion: k8s.String("v1"),
+			Namespace:  k8s.String(pod.Namespace),
+			Name:       k8s.String(pod.Name),
+			Uid:        k8s.String(pod.UID),
+		},
+		Reason:         k8s.String(reason),
+		Message:        k8s.String(message),
+		Type:           k8s.String(eventType),
+		Source:         &v1.EventSource{Component: k8s.String("kubernetes-vault")},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          &count,
+	})
+
+	if err != nil {
+		return errors.Wrapf(err, "could not create event for pod %s", pod.Name)
+	}
+
+	return nil
+}
+
 func (k *Kube) Discover(serviceNamespace, service string) ([]string, error) {
 
 	ips := []string{}
@@ -209,25 +837,32 @@ func (k *Kube) isInWatchedNamespace(namespace string) bool {
 	return k.watchNamespaceRegex.MatchString(namespace)
 }
 
-func NewKube(watchNamespace string, logger *logrus.Logger) (*Kube, error) {
+// NamespaceRegex compiles a namespace, or a regex if the namespace is prefixed with ~, into a case-insensitive regex.
+func NamespaceRegex(namespace string) (*regexp.Regexp, error) {
 
-	var (
-		r   *regexp.Regexp
-		err error
-	)
+	if namespace == "" {
+		return nil, errors.New("namespace is empty")
+	}
 
-	if string(watchNamespace[0]) == "~" {
-		r, err = regexp.Compile("(?i)" + string(watchNamespace[1:]))
+	if string(namespace[0]) == "~" {
+		r, err := regexp.Compile("(?i)" + string(namespace[1:]))
 
 		if err != nil {
-			return nil, errors.Wrap(err, "invalid regex for watching namespace")
+			return nil, errors.Wrap(err, "invalid regex for namespace")
 		}
-	} else {
-		r, err = regexp.Compile("(?i)^" + regexp.QuoteMeta(watchNamespace) + "$")
 
-		if err != nil {
-			return nil, errors.Wrap(err, "invalid watch namespace")
-		}
+		return r, nil
+	}
+
+	return regexp.MustCompile("(?i)^" + regexp.QuoteMeta(namespace) + "$"), nil
+}
+
+func NewKube(watchNamespace string, allowedImages []string, allowedDigests []string, logger *logrus.Logger) (*Kube, error) {
+
+	r, err := NamespaceRegex(watchNamespace)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid watch namespace")
 	}
 
 	client, err := k8s.NewInClusterClient()
@@ -236,9 +871,24 @@ func NewKube(watchNamespace string, logger *logrus.Logger) (*Kube, error) {
 		return nil, errors.Wrap(err, "could not create kubernetes client")
 	}
 
+	images := map[string]bool{}
+
+	for _, image := range allowedImages {
+		images[imageRepository(image)] = true
+	}
+
+	digests := map[string]bool{}
+
+	for _, digest := range allowedDigests {
+		digests[digest] = true
+	}
+
 	return &Kube{
 		client:              client,
 		watchNamespaceRegex: r,
+		allowedImages:       images,
+		allowedDigests:      digests,
 		logger:              logger,
+		rejected:            map[string]bool{},
 	}, nil
 }
@@ -0,0 +1,82 @@
+package client
+
+import "testing"
+
+func TestImageRepository(t *testing.T) {
+
+	tests := []struct {
+		image      string
+		repository string
+	}{
+		{image: "boostport/kubernetes-vault-init", repository: "boostport/kubernetes-vault-init"},
+		{image: "boostport/kubernetes-vault-init:0.7.0", repository: "boostport/kubernetes-vault-init"},
+		{image: "docker.io/boostport/kubernetes-vault-init:0.7.0", repository: "boostport/kubernetes-vault-init"},
+		{image: "docker.io/library/busybox:1.36", repository: "busybox"},
+		{image: "library/busybox", repository: "busybox"},
+		{image: "registry.example.com:5000/boostport/kubernetes-vault-init", repository: "registry.example.com:5000/boostport/kubernetes-vault-init"},
+		{image: "registry.example.com:5000/boostport/kubernetes-vault-init:0.7.0", repository: "registry.example.com:5000/boostport/kubernetes-vault-init"},
+		{image: "localhost:5000/kubernetes-vault-init:0.7.0", repository: "localhost:5000/kubernetes-vault-init"},
+		{image: "boostport/kubernetes-vault-init@sha256:2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d", repository: "boostport/kubernetes-vault-init"},
+		{image: "registry.example.com:5000/boostport/kubernetes-vault-init:0.7.0@sha256:2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d", repository: "registry.example.com:5000/boostport/kubernetes-vault-init"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+
+			if repository := imageRepository(test.image); repository != test.repository {
+				t.Errorf("expected %s, got %s", test.repository, repository)
+			}
+		})
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+
+	const digest = "sha256:2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d2c4a9e1f3b57e3d9f5b27a1c4f0e8b6d"
+
+	tests := []struct {
+		name    string
+		imageID string
+		digest  string
+	}{
+		{
+			name:    "docker-pullable image id",
+			imageID: "docker-pullable://boostport/kubernetes-vault-init@" + digest,
+			digest:  digest,
+		},
+		{
+			name:    "docker-pullable image id with a registry port",
+			imageID: "docker-pullable://registry.example.com:5000/boostport/kubernetes-vault-init@" + digest,
+			digest:  digest,
+		},
+		{
+			name:    "repository digest",
+			imageID: "docker.io/boostport/kubernetes-vault-init@" + digest,
+			digest:  digest,
+		},
+		{
+			name:    "image digest",
+			imageID: digest,
+			digest:  digest,
+		},
+		{
+			name:    "docker image id without a digest",
+			imageID: "docker://" + digest,
+			digest:  "",
+		},
+		{
+			name:    "empty image id",
+			imageID: "",
+			digest:  "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if result := imageDigest(test.imageID); result != test.digest {
+				t.Errorf("expected %q, got %q", test.digest, result)
+			}
+		})
+	}
+}
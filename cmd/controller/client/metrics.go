@@ -28,6 +28,60 @@ var (
 		[]string{"approle"},
 	)
 
+	roleIdRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "role_id_requests_total",
+		Help:      "The total number of requests for an approle's role_id.",
+	},
+		[]string{"approle"},
+	)
+
+	roleIdRequestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "role_id_requests_failures_total",
+		Help:      "The total number of requests for an approle's role_id that failed.",
+	},
+		[]string{"approle"},
+	)
+
+	tokenRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "token_requests_total",
+		Help:      "The total number of requests for a token using a token role.",
+	},
+		[]string{"token_role"},
+	)
+
+	tokenRequestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "token_requests_failures_total",
+		Help:      "The total number of requests for a token using a token role that failed.",
+	},
+		[]string{"token_role"},
+	)
+
+	wrappedSecretRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "wrapped_secret_requests_total",
+		Help:      "The total number of requests for a wrapped secret requested by a pod.",
+	},
+		[]string{"path"},
+	)
+
+	wrappedSecretRequestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "vault",
+		Name:      "wrapped_secret_requests_failures_total",
+		Help:      "The total number of requests for a wrapped secret requested by a pod that failed.",
+	},
+		[]string{"path"},
+	)
+
 	tokenRenewalRequests = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: "kubernetesvault",
 		Subsystem: "vault",
@@ -61,6 +115,12 @@ func init() {
 	prometheus.MustRegister(kubeDiscoveredNodes)
 	prometheus.MustRegister(secretIdRequests)
 	prometheus.MustRegister(secretIdRequestFailures)
+	prometheus.MustRegister(roleIdRequests)
+	prometheus.MustRegister(roleIdRequestFailures)
+	prometheus.MustRegister(tokenRequests)
+	prometheus.MustRegister(tokenRequestFailures)
+	prometheus.MustRegister(wrappedSecretRequests)
+	prometheus.MustRegister(wrappedSecretRequestFailures)
 	prometheus.MustRegister(tokenRenewalRequests)
 	prometheus.MustRegister(tokenRenewalFailures)
 	prometheus.MustRegister(certificateRenewalRequests)
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	APIGroup            = "vault.boostport.com"
+	APIVersion          = "v1"
+	RoleBindingResource = "vaultrolebindings"
+
+	roleBindingValidCondition = "Valid"
+)
+
+// VaultRoleBinding binds service accounts or pods matching a label selector in a namespace to AppRoles, token roles and
+// the paths of additional secrets.
+type VaultRoleBinding struct {
+	ApiVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   map[string]interface{}  `json:"metadata"`
+	Spec       VaultRoleBindingSpec    `json:"spec"`
+	Status     *VaultRoleBindingStatus `json:"status,omitempty"`
+}
+
+type VaultRoleBindingSpec struct {
+	Roles           []string          `json:"roles,omitempty"`
+	TokenRoles      []string          `json:"tokenRoles,omitempty"`
+	SecretPaths     []string          `json:"secretPaths,omitempty"`
+	ServiceAccounts []string          `json:"serviceAccounts,omitempty"`
+	Selector        map[string]string `json:"selector,omitempty"`
+	WrappingTTL     string            `json:"wrappingTTL,omitempty"`
+	CIDRs           []string          `json:"cidrs,omitempty"`
+}
+
+type VaultRoleBindingStatus struct {
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Condition is a status condition of a vault.boostport.com custom resource.
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+type vaultRoleBindingList struct {
+	Items []VaultRoleBinding `json:"items"`
+}
+
+func (b VaultRoleBinding) name() string {
+	name, _ := b.Metadata["name"].(string)
+	return name
+}
+
+// validate checks the spec of the binding. Invalid bindings do not grant anything.
+func (b VaultRoleBinding) validate() error {
+
+	if len(b.Spec.Roles) == 0 && len(b.Spec.TokenRoles) == 0 {
+		return errors.New("one of spec.roles or spec.tokenRoles is required")
+	}
+
+	if len(b.Spec.ServiceAccounts) == 0 && len(b.Spec.Selector) == 0 {
+		return errors.New("one of spec.serviceAccounts or spec.selector is required")
+	}
+
+	if b.Spec.WrappingTTL != "" {
+		if _, err := time.ParseDuration(b.Spec.WrappingTTL); err != nil {
+			return errors.Errorf("spec.wrappingTTL (%s) is not a valid duration", b.Spec.WrappingTTL)
+		}
+	}
+
+	for _, cidr := range b.Spec.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Errorf("spec.cidrs contains an invalid CIDR (%s)", cidr)
+		}
+	}
+
+	for _, pattern := range b.Spec.SecretPaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("spec.secretPaths contains an invalid pattern (%s)", pattern)
+		}
+	}
+
+	return nil
+}
+
+// matches returns whether the binding applies to the pod, either by its service account or its labels.
+func (b VaultRoleBinding) matches(pod Pod) bool {
+
+	for _, serviceAccount := range b.Spec.ServiceAccounts {
+		if serviceAccount == pod.ServiceAccount {
+			return true
+		}
+	}
+
+	if len(b.Spec.Selector) == 0 {
+		return false
+	}
+
+	for key, value := range b.Spec.Selector {
+		if pod.Labels[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetVaultRoleBindings lists the VaultRoleBindings in a namespace, sorted by name.
+func (k *Kube) GetVaultRoleBindings(namespace string) ([]VaultRoleBinding, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var list vaultRoleBindingList
+
+	err := k.client.ThirdPartyResources(APIGroup, APIVersion).List(ctx, RoleBindingResource, namespace, &list)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list vault role bindings")
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].name() < list.Items[j].name()
+	})
+
+	return list.Items, nil
+}
+
+// RefreshVaultRoleBindings lists the VaultRoleBindings in a namespace, records whether each one is valid in its Valid
+// status condition and returns the valid ones, sorted by name.
+func (k *Kube) RefreshVaultRoleBindings(namespace string) ([]VaultRoleBinding, error) {
+
+	bindings, err := k.GetVaultRoleBindings(namespace)
+
+	if err != nil {
+		return nil, err
+	}
+
+	valid := []VaultRoleBinding{}
+
+	for _, binding := range bindings {
+
+		err := binding.validate()
+
+		k.updateRoleBindingStatus(namespace, binding, err)
+
+		if err == nil {
+			valid = append(valid, binding)
+		}
+	}
+
+	return valid, nil
+}
+
+// RoleBindings are the valid VaultRoleBindings of each namespace, sorted by name.
+type RoleBindings map[string][]VaultRoleBinding
+
+// AuthorizePod returns the options for the secret_id of each AppRole, or for the token of its token role, if the pod is
+// bound to all of its AppRoles or its token role, and to the paths of all of its additional secrets, by the bindings in
+// its namespace. If several bindings bind an AppRole or token role, the first one by name is used.
+func (r RoleBindings) AuthorizePod(pod Pod) (map[string]SecretIdOptions, error) {
+
+	options := map[string]SecretIdOptions{}
+	tokenRoles := map[string]SecretIdOptions{}
+	secretPaths := []string{}
+
+	for _, binding := range r[pod.Namespace] {
+
+		if !binding.matches(pod) {
+			continue
+		}
+
+		for _, role := range binding.Spec.Roles {
+
+			if _, ok := options[role]; ok {
+				continue
+			}
+
+			options[role] = SecretIdOptions{
+				WrappingTTL: binding.Spec.WrappingTTL,
+				CIDRs:       binding.Spec.CIDRs,
+			}
+		}
+
+		for _, tokenRole := range binding.Spec.TokenRoles {
+
+			if _, ok := tokenRoles[tokenRole]; ok {
+				continue
+			}
+
+			tokenRoles[tokenRole] = SecretIdOptions{
+				WrappingTTL: binding.Spec.WrappingTTL,
+			}
+		}
+
+		secretPaths = append(secretPaths, binding.Spec.SecretPaths...)
+	}
+
+	if pod.TokenRole != "" {
+
+		tokenOptions, ok := tokenRoles[pod.TokenRole]
+
+		if !ok {
+			return nil, errors.Errorf("pod (%s) is not bound to the token role (%s) by a VaultRoleBinding", pod.Name, pod.TokenRole)
+		}
+
+		options = map[string]SecretIdOptions{pod.TokenRole: tokenOptions}
+
+	} else {
+
+		roles := []string{pod.Role}
+
+		if len(pod.AppRoles) > 0 {
+
+			roles = []string{}
+
+			for _, appRole := range pod.AppRoles {
+				roles = append(roles, appRole.Role)
+			}
+		}
+
+		for _, role := range roles {
+			if _, ok := options[role]; !ok {
+				return nil, errors.Errorf("pod (%s) is not bound to the role (%s) by a VaultRoleBinding", pod.Name, role)
+			}
+		}
+	}
+
+	for _, secret := range pod.Secrets {
+
+		if !MatchesSecretPath(secretPaths, secret.Path) {
+			return nil, errors.Errorf("pod (%s) is not bound to the path (%s) of secret (%s) by a VaultRoleBinding", pod.Name, secret.Path, secret.Name)
+		}
+	}
+
+	return options, nil
+}
+
+// MatchesSecretPath returns whether a secret path matches any of the patterns. Leading and trailing slashes are
+// ignored.
+func MatchesSecretPath(patterns []string, secretPath string) bool {
+
+	secretPath = strings.Trim(secretPath, "/")
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.Trim(pattern, "/"), secretPath); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateRoleBindingStatus sets the Valid condition of a binding using the status subresource, if it changed.
+func (k *Kube) updateRoleBindingStatus(namespace string, binding VaultRoleBinding, validationErr error) {
+
+	condition := Condition{
+		Type:   roleBindingValidCondition,
+		Status: "True",
+	}
+
+	if validationErr != nil {
+		condition.Status = "False"
+		condition.Reason = "InvalidSpec"
+		condition.Message = validationErr.Error()
+	}
+
+	if binding.Status != nil {
+		for _, c := range binding.Status.Conditions {
+			if c.Type == condition.Type && c.Status == condition.Status && c.Message == condition.Message {
+				return
+			}
+		}
+	}
+
+	condition.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+
+	binding.Status = &VaultRoleBindingStatus{
+		Conditions: []Condition{condition},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var updated VaultRoleBinding
+
+	err := k.client.ThirdPartyResources(APIGroup, APIVersion).Update(ctx, RoleBindingResource, namespace, binding.name()+"/status", &binding, &updated)
+
+	if err != nil {
+		k.logger.Errorf("Could not update the status of the vault role binding (%s/%s): %s", namespace, binding.name(), err)
+	}
+}
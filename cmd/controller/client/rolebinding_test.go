@@ -0,0 +1,298 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testRoleBinding(name string, spec VaultRoleBindingSpec) VaultRoleBinding {
+	return VaultRoleBinding{
+		ApiVersion: APIGroup + "/" + APIVersion,
+		Kind:       "VaultRoleBinding",
+		Metadata:   map[string]interface{}{"name": name},
+		Spec:       spec,
+	}
+}
+
+func TestValidateRoleBinding(t *testing.T) {
+
+	tests := []struct {
+		name string
+		spec VaultRoleBindingSpec
+		err  string
+	}{
+		{
+			name: "valid",
+			spec: VaultRoleBindingSpec{Roles: []string{"sample-app"}, ServiceAccounts: []string{"sample-app"}, WrappingTTL: "30s", CIDRs: []string{"10.2.0.0/16"}, SecretPaths: []string{"secret/sample-app/*"}},
+		},
+		{
+			name: "no roles",
+			spec: VaultRoleBindingSpec{ServiceAccounts: []string{"sample-app"}},
+			err:  "spec.roles",
+		},
+		{
+			name: "no subjects",
+			spec: VaultRoleBindingSpec{Roles: []string{"sample-app"}},
+			err:  "spec.serviceAccounts",
+		},
+		{
+			name: "invalid wrapping TTL",
+			spec: VaultRoleBindingSpec{Roles: []string{"sample-app"}, ServiceAccounts: []string{"sample-app"}, WrappingTTL: "30"},
+			err:  "spec.wrappingTTL",
+		},
+		{
+			name: "invalid CIDR",
+			spec: VaultRoleBindingSpec{Roles: []string{"sample-app"}, ServiceAccounts: []string{"sample-app"}, CIDRs: []string{"10.2.0.0"}},
+			err:  "spec.cidrs",
+		},
+		{
+			name: "invalid secret path pattern",
+			spec: VaultRoleBindingSpec{Roles: []string{"sample-app"}, ServiceAccounts: []string{"sample-app"}, SecretPaths: []string{"secret/["}},
+			err:  "spec.secretPaths",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := testRoleBinding("binding", test.spec).validate()
+
+			if test.err == "" && err != nil {
+				t.Errorf("expected the binding to be valid, got: %s", err)
+			}
+
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRoleBindingMatches(t *testing.T) {
+
+	pod := Pod{
+		Name:           "sample-app-1",
+		Namespace:      "default",
+		ServiceAccount: "sample-app",
+		Labels:         map[string]string{"app": "web", "tier": "frontend"},
+	}
+
+	tests := []struct {
+		name    string
+		spec    VaultRoleBindingSpec
+		matches bool
+	}{
+		{
+			name:    "service account",
+			spec:    VaultRoleBindingSpec{ServiceAccounts: []string{"other", "sample-app"}},
+			matches: true,
+		},
+		{
+			name:    "other service account",
+			spec:    VaultRoleBindingSpec{ServiceAccounts: []string{"other"}},
+			matches: false,
+		},
+		{
+			name:    "selector",
+			spec:    VaultRoleBindingSpec{Selector: map[string]string{"app": "web", "tier": "frontend"}},
+			matches: true,
+		},
+		{
+			name:    "selector with a missing label",
+			spec:    VaultRoleBindingSpec{Selector: map[string]string{"app": "web", "tier": "backend"}},
+			matches: false,
+		},
+		{
+			name:    "other service account and matching selector",
+			spec:    VaultRoleBindingSpec{ServiceAccounts: []string{"other"}, Selector: map[string]string{"app": "web"}},
+			matches: true,
+		},
+		{
+			name:    "no subjects",
+			spec:    VaultRoleBindingSpec{},
+			matches: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if matches := testRoleBinding("binding", test.spec).matches(pod); matches != test.matches {
+				t.Errorf("expected %t, got %t", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestMatchesSecretPath(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		matches  bool
+	}{
+		{
+			name:     "exact path",
+			patterns: []string{"secret/sample-app/db"},
+			path:     "secret/sample-app/db",
+			matches:  true,
+		},
+		{
+			name:     "wildcard",
+			patterns: []string{"secret/other/*", "secret/sample-app/*"},
+			path:     "secret/sample-app/db",
+			matches:  true,
+		},
+		{
+			name:     "wildcard does not match nested paths",
+			patterns: []string{"secret/sample-app/*"},
+			path:     "secret/sample-app/db/password",
+			matches:  false,
+		},
+		{
+			name:     "leading and trailing slashes",
+			patterns: []string{"/secret/sample-app/*/"},
+			path:     "/secret/sample-app/db",
+			matches:  true,
+		},
+		{
+			name:     "other path",
+			patterns: []string{"secret/sample-app/*"},
+			path:     "secret/other/db",
+			matches:  false,
+		},
+		{
+			name:    "no patterns",
+			path:    "secret/sample-app/db",
+			matches: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if matches := MatchesSecretPath(test.patterns, test.path); matches != test.matches {
+				t.Errorf("expected %t, got %t", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestAuthorizePod(t *testing.T) {
+
+	bindings := RoleBindings{
+		"default": {
+			testRoleBinding("a-web", VaultRoleBindingSpec{
+				Roles:       []string{"sample-app"},
+				SecretPaths: []string{"secret/sample-app/*"},
+				Selector:    map[string]string{"app": "web"},
+				WrappingTTL: "30s",
+				CIDRs:       []string{"10.2.0.0/16"},
+			}),
+			testRoleBinding("b-service-account", VaultRoleBindingSpec{
+				Roles:           []string{"sample-app", "metrics"},
+				TokenRoles:      []string{"sample-token"},
+				ServiceAccounts: []string{"sample-app"},
+				WrappingTTL:     "2m",
+			}),
+		},
+		"other": {
+			testRoleBinding("other", VaultRoleBindingSpec{
+				Roles:           []string{"other-app"},
+				ServiceAccounts: []string{"sample-app"},
+			}),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		pod     Pod
+		options map[string]SecretIdOptions
+		err     string
+	}{
+		{
+			name: "AppRole bound by the first binding by name",
+			pod:  Pod{Name: "web", Namespace: "default", ServiceAccount: "sample-app", Labels: map[string]string{"app": "web"}, Role: "sample-app"},
+			options: map[string]SecretIdOptions{
+				"sample-app": {WrappingTTL: "30s", CIDRs: []string{"10.2.0.0/16"}},
+				"metrics":    {WrappingTTL: "2m"},
+			},
+		},
+		{
+			name: "several AppRoles",
+			pod:  Pod{Name: "worker", Namespace: "default", ServiceAccount: "sample-app", AppRoles: []AppRoleRequest{{Name: "app", Role: "sample-app"}, {Name: "metrics", Role: "metrics"}}},
+			options: map[string]SecretIdOptions{
+				"sample-app": {WrappingTTL: "2m"},
+				"metrics":    {WrappingTTL: "2m"},
+			},
+		},
+		{
+			name:    "token role",
+			pod:     Pod{Name: "worker", Namespace: "default", ServiceAccount: "sample-app", TokenRole: "sample-token"},
+			options: map[string]SecretIdOptions{"sample-token": {WrappingTTL: "2m"}},
+		},
+		{
+			name: "unbound token role",
+			pod:  Pod{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}, TokenRole: "sample-token"},
+			err:  "token role (sample-token)",
+		},
+		{
+			name: "unbound AppRole",
+			pod:  Pod{Name: "worker", Namespace: "default", ServiceAccount: "sample-app", AppRoles: []AppRoleRequest{{Name: "app", Role: "sample-app"}, {Name: "admin", Role: "admin"}}},
+			err:  "role (admin)",
+		},
+		{
+			name: "pod not matched by any binding",
+			pod:  Pod{Name: "db", Namespace: "default", ServiceAccount: "db", Role: "sample-app"},
+			err:  "role (sample-app)",
+		},
+		{
+			name: "binding in another namespace",
+			pod:  Pod{Name: "web", Namespace: "default", ServiceAccount: "sample-app", Role: "other-app"},
+			err:  "role (other-app)",
+		},
+		{
+			name: "namespace without bindings",
+			pod:  Pod{Name: "web", Namespace: "empty", ServiceAccount: "sample-app", Role: "sample-app"},
+			err:  "role (sample-app)",
+		},
+		{
+			name: "bound secret path",
+			pod:  Pod{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}, Role: "sample-app", Secrets: []SecretRequest{{Name: "db", Path: "secret/sample-app/db"}}},
+			options: map[string]SecretIdOptions{
+				"sample-app": {WrappingTTL: "30s", CIDRs: []string{"10.2.0.0/16"}},
+			},
+		},
+		{
+			name: "unbound secret path",
+			pod:  Pod{Name: "worker", Namespace: "default", ServiceAccount: "sample-app", Role: "sample-app", Secrets: []SecretRequest{{Name: "db", Path: "secret/sample-app/db"}}},
+			err:  "path (secret/sample-app/db)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			options, err := bindings.AuthorizePod(test.pod)
+
+			if test.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got: %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(options, test.options) {
+				t.Errorf("expected options %v, got %v", test.options, options)
+			}
+		})
+	}
+}
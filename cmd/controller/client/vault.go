@@ -9,7 +9,10 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Boostport/kubernetes-vault/common"
@@ -25,8 +28,6 @@ import (
 	"golang.org/x/net/context/ctxhttp"
 )
 
-var wrappedSecretIdRegex = regexp.MustCompile(`auth/approle/role/.+/secret-id`)
-
 // tokenData holds the relevant information about the Vault token passed to the
 // client.
 type tokenData struct {
@@ -123,20 +124,82 @@ func (e *ExternalRootCAsResolver) GetRootCAs() ([]byte, *x509.CertPool, error) {
 }
 
 type Vault struct {
-	vaultAddr       string
-	vaultRootCAs    []byte
-	token           string
-	skipTokenRoleNameValidation   	bool
-	kubeServiceName string
-	client          *api.Client
-	tokenData       *tokenData
-	logger          *logrus.Logger
-	shutdown        chan struct{}
+	vaultAddr                   string
+	vaultRootCAs                []byte
+	token                       string
+	skipTokenRoleNameValidation bool
+	kubeServiceName             string
+	appRoleMountPath            string
+	appRoleMountPaths           map[string]bool
+	allowedSecretPaths          []string
+	wrappingTTL                 string
+	supplyRoleIds               bool
+	roleIds                     map[string]cachedRoleId
+	roleIdsLock                 sync.Mutex
+	client                      *api.Client
+	tokenData                   *tokenData
+	logger                      *logrus.Logger
+	shutdown                    chan struct{}
+}
+
+// ErrRoleNotFound is returned by GetRoleId if the AppRole does not exist and by CheckTokenRole if the token role does not
+// exist.
+var ErrRoleNotFound = errors.New("role does not exist")
+
+// roleIdCacheTTL is how long a role_id is cached. role_ids rarely change, so this only bounds how long a changed
+// role_id takes to be picked up.
+const roleIdCacheTTL = 5 * time.Minute
+
+type cachedRoleId struct {
+	roleId  string
+	expires time.Time
+}
+
+// SecretIdOptions restricts a secret_id beyond what its AppRole allows.
+type SecretIdOptions struct {
+	// WrappingTTL overrides the configured wrapping TTL if set.
+	WrappingTTL string
+
+	// CIDRs restricts the addresses the secret_id can be used from if set.
+	CIDRs []string
 }
 
-func (v *Vault) GetSecretId(role string) (common.WrappedSecretId, error) {
+func (v *Vault) GetSecretId(role string, mountPath string, options SecretIdOptions) (common.WrappedSecretId, error) {
 
-	s, err := v.client.Logical().Write(fmt.Sprintf("auth/approle/role/%s/secret-id", role), map[string]interface{}{})
+	mountPath, err := v.resolveAppRoleMountPath(mountPath)
+
+	if err != nil {
+		return common.WrappedSecretId{}, err
+	}
+
+	roleId := ""
+
+	// The role_id is read first, so that a wrapped secret_id is never issued and then dropped because of it.
+	if v.supplyRoleIds {
+		roleId, err = v.GetRoleId(role, mountPath)
+
+		if err != nil {
+			return common.WrappedSecretId{}, err
+		}
+	}
+
+	data := map[string]interface{}{}
+
+	if len(options.CIDRs) > 0 {
+		data["cidr_list"] = strings.Join(options.CIDRs, ",")
+	}
+
+	r := v.client.NewRequest("PUT", fmt.Sprintf("/v1/auth/%s/role/%s/secret-id", mountPath, role))
+
+	if options.WrappingTTL != "" {
+		r.WrapTTL = options.WrappingTTL
+	}
+
+	if err := r.SetJSONBody(data); err != nil {
+		return common.WrappedSecretId{}, errors.Wrap(err, "could not encode request data")
+	}
+
+	s, err := v.doRequest(r)
 
 	secretIdRequests.With(prometheus.Labels{"approle": role}).Inc()
 
@@ -145,16 +208,437 @@ func (v *Vault) GetSecretId(role string) (common.WrappedSecretId, error) {
 		return common.WrappedSecretId{}, errors.Wrap(err, "could not get secret_id")
 	}
 
+	return common.WrappedSecretId{
+		SecretID:         s.WrapInfo.Token,
+		CreationTime:     s.WrapInfo.CreationTime,
+		TTL:              s.WrapInfo.TTL,
+		VaultAddr:        v.vaultAddr,
+		VaultCAs:         v.vaultRootCAs,
+		AppRoleMountPath: mountPath,
+		DeliveryType:     common.SecretIdDelivery,
+		Role:             role,
+		RoleID:           roleId,
+	}, nil
+}
+
+// GetRoleId reads the role_id of an AppRole. role_ids are cached for roleIdCacheTTL.
+func (v *Vault) GetRoleId(role string, mountPath string) (string, error) {
+
+	mountPath, err := v.resolveAppRoleMountPath(mountPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	key := mountPath + "/" + role
+
+	v.roleIdsLock.Lock()
+	cached, ok := v.roleIds[key]
+	v.roleIdsLock.Unlock()
+
+	if ok && cached.expires.After(time.Now()) {
+		return cached.roleId, nil
+	}
+
+	s, err := v.client.Logical().Read(fmt.Sprintf("auth/%s/role/%s/role-id", mountPath, role))
+
+	roleIdRequests.With(prometheus.Labels{"approle": role}).Inc()
+
+	if err != nil {
+		roleIdRequestFailures.With(prometheus.Labels{"approle": role}).Inc()
+		return "", errors.Wrap(err, "could not get role_id")
+	}
+
+	if s == nil {
+		roleIdRequestFailures.With(prometheus.Labels{"approle": role}).Inc()
+		return "", ErrRoleNotFound
+	}
+
+	roleId, ok := s.Data["role_id"].(string)
+
+	if !ok || roleId == "" {
+		roleIdRequestFailures.With(prometheus.Labels{"approle": role}).Inc()
+		return "", errors.Errorf("response for role (%s) does not contain a role_id", role)
+	}
+
+	v.roleIdsLock.Lock()
+	v.roleIds[key] = cachedRoleId{
+		roleId:  roleId,
+		expires: time.Now().Add(roleIdCacheTTL),
+	}
+	v.roleIdsLock.Unlock()
+
+	return roleId, nil
+}
+
+// CheckTokenRole checks that a token role exists.
+func (v *Vault) CheckTokenRole(tokenRole string) error {
+
+	s, err := v.client.Logical().Read(fmt.Sprintf("auth/token/roles/%s", tokenRole))
+
+	if err != nil {
+		return errors.Wrap(err, "could not read token role")
+	}
+
+	if s == nil {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// GetSecretIds gets a wrapped secret_id for each of the AppRoles and returns them in a single payload. options are
+// looked up by role.
+func (v *Vault) GetSecretIds(appRoles []AppRoleRequest, mountPath string, options map[string]SecretIdOptions) (common.WrappedSecretId, error) {
+
+	wrappedSecretIds := common.WrappedSecretId{
+		VaultAddr:    v.vaultAddr,
+		VaultCAs:     v.vaultRootCAs,
+		DeliveryType: common.AppRolesDelivery,
+	}
+
+	for _, appRole := range appRoles {
+
+		wrappedSecretId, err := v.GetSecretId(appRole.Role, mountPath, options[appRole.Role])
+
+		if err != nil {
+
+			err = errors.Wrapf(err, "could not get secret_id for AppRole (%s)", appRole.Name)
+
+			if revokeErr := v.RevokeWrappedSecret(wrappedSecretIds); revokeErr != nil {
+				err = multierror.Append(err, errors.Wrap(revokeErr, "could not revoke the secret_ids issued before"))
+			}
+
+			return common.WrappedSecretId{}, err
+		}
+
+		wrappedSecretIds.AppRoles = append(wrappedSecretIds.AppRoles, common.WrappedAppRole{
+			Name:             appRole.Name,
+			Role:             appRole.Role,
+			SecretID:         wrappedSecretId.SecretID,
+			CreationTime:     wrappedSecretId.CreationTime,
+			TTL:              wrappedSecretId.TTL,
+			AppRoleMountPath: wrappedSecretId.AppRoleMountPath,
+			RoleID:           wrappedSecretId.RoleID,
+		})
+	}
+
+	return wrappedSecretIds, nil
+}
+
+// ReconcileAppRole creates or updates the AppRole in Vault if it differs from the spec. It returns whether the role
+// was Created, Updated or already InSync, and the mount path it was written to.
+func (v *Vault) ReconcileAppRole(name string, spec VaultAppRoleSpec) (string, string, error) {
+
+	mountPath, err := v.resolveAppRoleMountPath(spec.MountPath)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	desired, err := spec.parameters()
+
+	if err != nil {
+		return "", mountPath, err
+	}
+
+	rolePath := fmt.Sprintf("auth/%s/role/%s", mountPath, name)
+
+	current, err := v.client.Logical().Read(rolePath)
+
+	if err != nil {
+		return "", mountPath, errors.Wrapf(err, "could not read role (%s)", name)
+	}
+
+	result := "Created"
+
+	if current != nil {
+
+		result = "InSync"
+
+		for parameter, value := range desired {
+			if normalizeRoleParameter(parameter, value) != normalizeRoleParameter(parameter, current.Data[parameter]) {
+				result = "Updated"
+				break
+			}
+		}
+
+		if result == "InSync" {
+			return result, mountPath, nil
+		}
+	}
+
+	if _, err := v.client.Logical().Write(rolePath, desired); err != nil {
+		return "", mountPath, errors.Wrapf(err, "could not write role (%s)", name)
+	}
+
+	return result, mountPath, nil
+}
+
+// DeleteAppRole deletes the AppRole from Vault. Deleting a role that does not exist is not an error.
+func (v *Vault) DeleteAppRole(name string, mountPath string) error {
+
+	mountPath, err := v.resolveAppRoleMountPath(mountPath)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.client.Logical().Delete(fmt.Sprintf("auth/%s/role/%s", mountPath, name)); err != nil {
+		return errors.Wrapf(err, "could not delete role (%s)", name)
+	}
+
+	v.roleIdsLock.Lock()
+	delete(v.roleIds, mountPath+"/"+name)
+	v.roleIdsLock.Unlock()
+
+	return nil
+}
+
+// GetToken creates a wrapped token using a token role. The wrapping TTL in options overrides the configured one.
+func (v *Vault) GetToken(tokenRole string, options SecretIdOptions) (common.WrappedSecretId, error) {
+
+	r := v.client.NewRequest("PUT", fmt.Sprintf("/v1/auth/token/create/%s", tokenRole))
+	r.WrapTTL = v.wrappingTTL
+
+	if options.WrappingTTL != "" {
+		r.WrapTTL = options.WrappingTTL
+	}
+
+	if err := r.SetJSONBody(map[string]interface{}{}); err != nil {
+		return common.WrappedSecretId{}, errors.Wrap(err, "could not encode request data")
+	}
+
+	s, err := v.doRequest(r)
+
+	tokenRequests.With(prometheus.Labels{"token_role": tokenRole}).Inc()
+
+	if err != nil {
+		tokenRequestFailures.With(prometheus.Labels{"token_role": tokenRole}).Inc()
+		return common.WrappedSecretId{}, errors.Wrap(err, "could not create token")
+	}
+
 	return common.WrappedSecretId{
 		SecretID:     s.WrapInfo.Token,
 		CreationTime: s.WrapInfo.CreationTime,
 		TTL:          s.WrapInfo.TTL,
 		VaultAddr:    v.vaultAddr,
 		VaultCAs:     v.vaultRootCAs,
+		DeliveryType: common.TokenDelivery,
+		Role:         tokenRole,
 	}, nil
 }
 
-func NewVault(vaultAddr string, token string, skipTokenRoleNameValidation bool, kubeServiceName string, wrappingTTL string, caResolver RootCAResolver, logger *logrus.Logger) (*Vault, error) {
+// GetWrappedSecret requests an arbitrary Vault path with response wrapping. The request is a write if it has data,
+// otherwise it is a read.
+func (v *Vault) GetWrappedSecret(request SecretRequest) (common.WrappedSecret, error) {
+
+	secretPath := strings.Trim(request.Path, "/")
+
+	if !v.isAllowedSecretPath(secretPath) {
+		return common.WrappedSecret{}, errors.Errorf("the path (%s) is not in the list of allowed secret paths", secretPath)
+	}
+
+	method := "GET"
+
+	if len(request.Data) > 0 {
+		method = "PUT"
+	}
+
+	r := v.client.NewRequest(method, "/v1/"+secretPath)
+	r.WrapTTL = v.wrappingTTL
+
+	if method == "PUT" {
+		if err := r.SetJSONBody(request.Data); err != nil {
+			return common.WrappedSecret{}, errors.Wrap(err, "could not encode request data")
+		}
+	}
+
+	wrappedSecretRequests.With(prometheus.Labels{"path": secretPath}).Inc()
+
+	s, err := v.doRequest(r)
+
+	if err != nil {
+		wrappedSecretRequestFailures.With(prometheus.Labels{"path": secretPath}).Inc()
+		return common.WrappedSecret{}, errors.Wrapf(err, "could not get wrapped response for %s", secretPath)
+	}
+
+	return common.WrappedSecret{
+		Name:         request.Name,
+		Path:         secretPath,
+		Token:        s.WrapInfo.Token,
+		CreationTime: s.WrapInfo.CreationTime,
+		TTL:          s.WrapInfo.TTL,
+	}, nil
+}
+
+// RevokeWrappedSecret discards a wrapped secret that will not be delivered. Each wrapping token is unwrapped, so that
+// it can no longer be used, and the secret_id or token it contained is destroyed. Leases of additional secrets are
+// revoked.
+func (v *Vault) RevokeWrappedSecret(w common.WrappedSecretId) error {
+
+	var errs error
+
+	switch w.GetDeliveryType() {
+	case common.TokenDelivery:
+		if w.SecretID != "" {
+			if err := v.revokeWrappedToken(w.SecretID); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+
+	case common.AppRolesDelivery:
+		for _, appRole := range w.AppRoles {
+			if err := v.destroyWrappedSecretId(appRole.SecretID, appRole.AppRoleMountPath, appRole.Role); err != nil {
+				errs = multierror.Append(errs, errors.Wrapf(err, "AppRole (%s)", appRole.Name))
+			}
+		}
+
+	default:
+		if w.SecretID != "" {
+			if err := v.destroyWrappedSecretId(w.SecretID, w.GetAppRoleMountPath(), w.Role); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	for _, secret := range w.Secrets {
+		if err := v.revokeWrappedSecretLease(secret.Token); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "secret (%s)", secret.Name))
+		}
+	}
+
+	return errs
+}
+
+func (v *Vault) destroyWrappedSecretId(wrappingToken string, mountPath string, role string) error {
+
+	s, err := v.client.Logical().Unwrap(wrappingToken)
+
+	if err != nil {
+		return errors.Wrap(err, "could not unwrap secret_id")
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	secretId, _ := s.Data["secret_id"].(string)
+
+	if _, err := v.client.Logical().Write(fmt.Sprintf("auth/%s/role/%s/secret-id/destroy", mountPath, role), map[string]interface{}{
+		"secret_id": secretId,
+	}); err != nil {
+		return errors.Wrap(err, "could not destroy secret_id")
+	}
+
+	return nil
+}
+
+func (v *Vault) revokeWrappedToken(wrappingToken string) error {
+
+	s, err := v.client.Logical().Unwrap(wrappingToken)
+
+	if err != nil {
+		return errors.Wrap(err, "could not unwrap token")
+	}
+
+	if s == nil || s.Auth == nil {
+		return nil
+	}
+
+	c, err := v.client.Clone()
+
+	if err != nil {
+		return errors.Wrap(err, "could not create vault client")
+	}
+
+	c.SetToken(s.Auth.ClientToken)
+
+	if err := c.Auth().Token().RevokeSelf(""); err != nil {
+		return errors.Wrap(err, "could not revoke token")
+	}
+
+	return nil
+}
+
+func (v *Vault) revokeWrappedSecretLease(wrappingToken string) error {
+
+	s, err := v.client.Logical().Unwrap(wrappingToken)
+
+	if err != nil {
+		return errors.Wrap(err, "could not unwrap secret")
+	}
+
+	if s == nil || s.LeaseID == "" {
+		return nil
+	}
+
+	if err := v.client.Sys().Revoke(s.LeaseID); err != nil {
+		return errors.Wrap(err, "could not revoke lease")
+	}
+
+	return nil
+}
+
+func (v *Vault) doRequest(r *api.Request) (*api.Secret, error) {
+
+	resp, err := v.client.RawRequest(r)
+
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse response")
+	}
+
+	if secret == nil || secret.WrapInfo == nil {
+		return nil, errors.New("response was not wrapped")
+	}
+
+	return secret, nil
+}
+
+func (v *Vault) isAllowedSecretPath(secretPath string) bool {
+
+	for _, pattern := range v.allowedSecretPaths {
+		if matched, _ := path.Match(strings.Trim(pattern, "/"), secretPath); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveAppRoleMountPath returns the default AppRole mount path if none is requested, otherwise it makes sure the
+// requested mount path is one of the configured mount paths.
+func (v *Vault) resolveAppRoleMountPath(mountPath string) (string, error) {
+
+	if mountPath == "" {
+		return v.appRoleMountPath, nil
+	}
+
+	mountPath = NormalizeAppRoleMountPath(mountPath)
+
+	if !v.appRoleMountPaths[mountPath] {
+		return "", errors.Errorf("the AppRole mount path (%s) is not in the list of allowed mount paths", mountPath)
+	}
+
+	return mountPath, nil
+}
+
+// NormalizeAppRoleMountPath strips the auth/ prefix and any leading or trailing slashes from an AppRole mount path.
+func NormalizeAppRoleMountPath(mountPath string) string {
+	return strings.TrimPrefix(strings.Trim(mountPath, "/"), "auth/")
+}
+
+func NewVault(vaultAddr string, token string, skipTokenRoleNameValidation bool, kubeServiceName string, wrappingTTL string, appRoleMountPath string, allowedAppRoleMountPaths []string, allowedSecretPaths []string, supplyRoleIds bool, caResolver RootCAResolver, logger *logrus.Logger) (*Vault, error) {
 
 	var (
 		certs []byte
@@ -185,34 +669,63 @@ func NewVault(vaultAddr string, token string, skipTokenRoleNameValidation bool,
 
 	client.SetToken(token)
 
+	appRoleMountPath = NormalizeAppRoleMountPath(appRoleMountPath)
+
+	appRoleMountPaths := map[string]bool{
+		appRoleMountPath: true,
+	}
+
+	for _, mountPath := range allowedAppRoleMountPaths {
+		appRoleMountPaths[NormalizeAppRoleMountPath(mountPath)] = true
+	}
+
 	v := &Vault{
-		vaultAddr:       vaultAddr,
-		vaultRootCAs:    certs,
-		token:           token,
-		skipTokenRoleNameValidation:	skipTokenRoleNameValidation,
-		kubeServiceName: kubeServiceName,
-		client:          client,
-		logger:          logger,
-		shutdown:        make(chan struct{}),
+		vaultAddr:                   vaultAddr,
+		vaultRootCAs:                certs,
+		token:                       token,
+		skipTokenRoleNameValidation: skipTokenRoleNameValidation,
+		kubeServiceName:             kubeServiceName,
+		appRoleMountPath:            appRoleMountPath,
+		appRoleMountPaths:           appRoleMountPaths,
+		allowedSecretPaths:          allowedSecretPaths,
+		wrappingTTL:                 wrappingTTL,
+		supplyRoleIds:               supplyRoleIds,
+		roleIds:                     map[string]cachedRoleId{},
+		client:                      client,
+		logger:                      logger,
+		shutdown:                    make(chan struct{}),
 	}
 
 	if err = v.parseToken(); err != nil {
 		return nil, errors.Wrap(err, "error parsing supplied token")
 	}
 
-	v.client.SetWrappingLookupFunc(getWrappingFn(wrappingTTL))
+	v.client.SetWrappingLookupFunc(getWrappingFn(wrappingTTL, wrappedSecretIdRegex(appRoleMountPaths)))
 
 	go v.renewToken()
 
 	return v, nil
 }
 
+// wrappedSecretIdRegex returns a regex that matches the secret_id creation path of every configured AppRole mount and
+// the token creation path of every token role
+func wrappedSecretIdRegex(mountPaths map[string]bool) *regexp.Regexp {
+
+	quoted := []string{}
+
+	for mountPath := range mountPaths {
+		quoted = append(quoted, regexp.QuoteMeta(mountPath))
+	}
+
+	return regexp.MustCompile(fmt.Sprintf(`^auth/((%s)/role/.+/secret-id|token/create/[^/]+)$`, strings.Join(quoted, "|")))
+}
+
 // getWrappingFn returns an appropriate wrapping function for Nomad Servers
-func getWrappingFn(wrappingTTL string) func(operation, path string) string {
+func getWrappingFn(wrappingTTL string, wrappedPathRegex *regexp.Regexp) func(operation, path string) string {
 
 	return func(operation, path string) string {
-		// Only wrap the token create operation
-		if operation != "PUT" || !wrappedSecretIdRegex.MatchString(path) {
+		// Only wrap the secret_id and token create operations
+		if operation != "PUT" || !wrappedPathRegex.MatchString(path) {
 			return ""
 		}
 
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/cenkalti/backoff"
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExpiresAnnotation records when the wrapped tokens in a Secret expire, so that unconsumed Secrets are deleted.
+	ExpiresAnnotation = "vault.boostport.com/expires"
+
+	wrappedSecretLabel      = "kubernetes-vault"
+	wrappedSecretLabelValue = "wrapped-secret"
+)
+
+// ErrWrappedSecretNotOwned is the cause of the error returned if the Secret the wrapped secret of a pod is written to
+// already exists and was not written for the pod.
+var ErrWrappedSecretNotOwned = errors.New("the secret already exists and was not written for the pod")
+
+// CheckWrappedSecret makes sure that the wrapped secret of a pod can be written before it is issued.
+func (k *Kube) CheckWrappedSecret(pod Pod) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	existing, err := k.getWrappedSecret(ctx, pod)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && !isWrappedSecretOf(existing, pod) {
+		return errors.Wrapf(ErrWrappedSecretNotOwned, "secret %s", common.WrappedSecretName(pod.Name))
+	}
+
+	return nil
+}
+
+// getWrappedSecret returns the Secret the wrapped secret of a pod is written to, or nil if it does not exist.
+func (k *Kube) getWrappedSecret(ctx context.Context, pod Pod) (*v1.Secret, error) {
+
+	name := common.WrappedSecretName(pod.Name)
+
+	secret, err := k.client.CoreV1().GetSecret(ctx, name, pod.Namespace)
+
+	if err != nil {
+
+		if apiErr, ok := err.(*k8s.APIError); ok && apiErr.Code == http.StatusNotFound {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "could not get secret %s", name)
+	}
+
+	return secret, nil
+}
+
+// WriteWrappedSecret writes the wrapped secret for a pod using secret delivery to a Secret owned by the pod, replacing
+// the Secret written for a previous init container. A Secret with the same name that was not written for the pod is
+// never overwritten.
+func (k *Kube) WriteWrappedSecret(pod Pod, wrappedSecret []byte, expires time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	name := common.WrappedSecretName(pod.Name)
+
+	secret := &v1.Secret{
+		Metadata: &metav1.ObjectMeta{
+			Name:      k8s.String(name),
+			Namespace: k8s.String(pod.Namespace),
+			Labels: map[string]string{
+				wrappedSecretLabel: wrappedSecretLabelValue,
+			},
+			Annotations: map[string]string{
+				ExpiresAnnotation: expires.UTC().Format(time.RFC3339),
+			},
+			OwnerReferences: []*metav1.OwnerReference{
+				{
+					ApiVersion: k8s.String("v1"),
+					Kind:       k8s.String("Pod"),
+					Name:       k8s.String(pod.Name),
+					Uid:        k8s.String(pod.UID),
+				},
+			},
+		},
+		Data: map[string][]byte{
+			common.WrappedSecretKey: wrappedSecret,
+		},
+		Type: k8s.String("Opaque"),
+	}
+
+	existing, err := k.getWrappedSecret(ctx, pod)
+
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+
+		_, err = k.client.CoreV1().CreateSecret(ctx, secret)
+
+		if err != nil {
+			return errors.Wrapf(err, "could not create secret %s", name)
+		}
+
+		return nil
+	}
+
+	if !isWrappedSecretOf(existing, pod) {
+		return backoff.Permanent(errors.Wrapf(ErrWrappedSecretNotOwned, "secret %s", name))
+	}
+
+	secret.Metadata.ResourceVersion = existing.Metadata.ResourceVersion
+
+	_, err = k.client.CoreV1().UpdateSecret(ctx, secret)
+
+	if err != nil {
+		return errors.Wrapf(err, "could not update secret %s", name)
+	}
+
+	return nil
+}
+
+// DeleteWrappedSecrets deletes the Secrets written for pods using secret delivery once the init container has
+// consumed them or the wrapped tokens have expired. It returns the number of deleted Secrets.
+func (k *Kube) DeleteWrappedSecrets() (int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	selector := new(k8s.LabelSelector)
+	selector.Eq(wrappedSecretLabel, wrappedSecretLabelValue)
+
+	secrets, err := k.client.CoreV1().ListSecrets(ctx, k8s.AllNamespaces, selector.Selector())
+
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list wrapped secrets")
+	}
+
+	deleted := 0
+
+	for _, secret := range secrets.Items {
+
+		metadata := secret.GetMetadata()
+
+		if !k.isInWatchedNamespace(metadata.GetNamespace()) {
+			continue
+		}
+
+		expires, err := time.Parse(time.RFC3339, metadata.Annotations[ExpiresAnnotation])
+
+		if metadata.Annotations[common.ConsumedAnnotation] != "true" && err == nil && time.Now().Before(expires) {
+			continue
+		}
+
+		if err := k.client.CoreV1().DeleteSecret(ctx, metadata.GetName(), metadata.GetNamespace()); err != nil {
+			return deleted, errors.Wrapf(err, "could not delete secret %s", metadata.GetName())
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// isWrappedSecretOf returns whether a Secret is labeled as a wrapped secret and owned by the pod.
+func isWrappedSecretOf(secret *v1.Secret, pod Pod) bool {
+
+	metadata := secret.GetMetadata()
+
+	if metadata.GetLabels()[wrappedSecretLabel] != wrappedSecretLabelValue {
+		return false
+	}
+
+	for _, owner := range metadata.GetOwnerReferences() {
+		if owner.GetKind() == "Pod" && owner.GetUid() == pod.UID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+)
+
+const appRoleReconciledCondition = "Reconciled"
+
+// reconcileAppRoles creates, updates and deletes AppRoles in Vault to match the VaultAppRoles in the controller's
+// namespace.
+func (s *Store) reconcileAppRoles() {
+
+	appRoles, err := s.kubeClient.GetVaultAppRoles(s.config.AppRoleNamespace)
+
+	if err != nil {
+		s.logger.Errorf("Could not list vault approles: %s", err)
+		return
+	}
+
+	for _, appRole := range appRoles {
+		s.reconcileAppRole(appRole)
+	}
+}
+
+func (s *Store) reconcileAppRole(appRole client.VaultAppRole) {
+
+	name := appRole.GetRoleName()
+
+	if appRole.IsDeleted() {
+
+		if !appRole.HasFinalizer() {
+			return
+		}
+
+		if err := s.vaultClient.DeleteAppRole(name, appRole.Spec.MountPath); err != nil {
+			appRoleReconciliationFailures.Inc()
+			s.logger.Errorf("Could not delete approle (%s): %s", name, err)
+			return
+		}
+
+		if status := appRole.Status; status != nil && status.RoleName != "" && (status.RoleName != name || status.MountPath != appRole.Spec.MountPath) {
+
+			if err := s.vaultClient.DeleteAppRole(status.RoleName, status.MountPath); err != nil {
+				appRoleReconciliationFailures.Inc()
+				s.logger.Errorf("Could not delete previous approle (%s/%s): %s", status.MountPath, status.RoleName, err)
+				return
+			}
+		}
+
+		s.logger.Debugf("Deleted approle (%s).", name)
+
+		appRole.RemoveFinalizer()
+
+		if err := s.kubeClient.UpdateVaultAppRole(s.config.AppRoleNamespace, appRole); err != nil {
+			s.logger.Errorf("Could not remove finalizer from vault approle (%s): %s", appRole.Name(), err)
+		}
+
+		return
+	}
+
+	appRoleReconciliations.Inc()
+
+	previous := appRole.Status
+
+	condition := client.Condition{
+		Type:   appRoleReconciledCondition,
+		Status: "True",
+	}
+
+	result, mountPath, err := s.vaultClient.ReconcileAppRole(name, appRole.Spec)
+
+	appliedRoleName, appliedMountPath := "", ""
+
+	if previous != nil {
+		appliedRoleName, appliedMountPath = previous.RoleName, previous.MountPath
+	}
+
+	if err == nil {
+
+		// The role was renamed or moved to another mount, so the role written before is deleted to not leave it
+		// behind with its policies.
+		if appliedRoleName != "" && (appliedRoleName != name || appliedMountPath != mountPath) {
+
+			if err = s.vaultClient.DeleteAppRole(appliedRoleName, appliedMountPath); err == nil {
+				s.logger.Debugf("Deleted previous approle (%s/%s).", appliedMountPath, appliedRoleName)
+			}
+		}
+
+		if err == nil {
+			appliedRoleName, appliedMountPath = name, mountPath
+		}
+	}
+
+	if err != nil {
+		appRoleReconciliationFailures.Inc()
+		s.logger.Errorf("Could not reconcile approle (%s): %s", name, err)
+
+		condition.Status = "False"
+		condition.Reason = "Error"
+		condition.Message = err.Error()
+	} else {
+		condition.Reason = result
+
+		if result != "InSync" {
+			s.logger.Debugf("Reconciled approle (%s): %s", name, result)
+		}
+	}
+
+	needsUpdate := !appRole.HasFinalizer() || statusChanged(previous, condition, appRole.Generation(), appRole.Spec.Warnings()) ||
+		previous.RoleName != appliedRoleName || previous.MountPath != appliedMountPath
+
+	if !needsUpdate {
+		return
+	}
+
+	if !appRole.HasFinalizer() {
+		appRole.AddFinalizer()
+	}
+
+	appRole.SetCondition(condition)
+	appRole.Status.ObservedGeneration = appRole.Generation()
+	appRole.Status.Warnings = appRole.Spec.Warnings()
+	appRole.Status.RoleName = appliedRoleName
+	appRole.Status.MountPath = appliedMountPath
+
+	if err := s.kubeClient.UpdateVaultAppRole(s.config.AppRoleNamespace, appRole); err != nil {
+		s.logger.Errorf("Could not update vault approle (%s): %s", appRole.Name(), err)
+	}
+}
+
+// statusChanged returns whether the status would change. Drift that was corrected (Created or Updated) is always
+// reported, so that the transition shows up on the resource.
+func statusChanged(status *client.VaultAppRoleStatus, condition client.Condition, generation int64, warnings []string) bool {
+
+	if status == nil || status.ObservedGeneration != generation || len(status.Warnings) != len(warnings) {
+		return true
+	}
+
+	for i := range warnings {
+		if status.Warnings[i] != warnings[i] {
+			return true
+		}
+	}
+
+	for _, c := range status.Conditions {
+		if c.Type == condition.Type {
+			return c.Status != condition.Status || c.Reason != condition.Reason || c.Message != condition.Message
+		}
+	}
+
+	return true
+}
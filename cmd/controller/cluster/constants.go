@@ -7,4 +7,5 @@ const (
 	defaultPollPodsFrequency = 20 * time.Second
 	HTTPPostTimeout          = 30 * time.Second
 	maxHTTPPostTime          = 3 * time.Minute
+	unwrapFailedRepushDelay  = 5 * time.Second
 )
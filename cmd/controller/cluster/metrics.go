@@ -70,6 +70,91 @@ var (
 	},
 		[]string{"approle"},
 	)
+
+	tokenPushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "token_pushes_total",
+		Help:      "The total number of tokens pushed.",
+	},
+		[]string{"token_role"},
+	)
+
+	tokenPushFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "token_push_failures_total",
+		Help:      "The total number of times a token push failed.",
+	},
+		[]string{"token_role"},
+	)
+
+	roleIdMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "role_id_mismatches_total",
+		Help:      "The total number of times a pod's role_id did not match its approle.",
+	},
+		[]string{"approle"},
+	)
+
+	roleBindingRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "role_binding_rejections_total",
+		Help:      "The total number of times a pod was not bound to its approles by a VaultRoleBinding.",
+	})
+
+	secretPathRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "secret_path_rejections_total",
+		Help:      "The total number of times a pod requested a secret path that is not granted to its roles.",
+	})
+
+	appRoleReconciliations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "approle_reconciliations_total",
+		Help:      "The total number of times a VaultAppRole was reconciled into Vault.",
+	})
+
+	appRoleReconciliationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "approle_reconciliation_failures_total",
+		Help:      "The total number of times a VaultAppRole could not be reconciled into Vault.",
+	})
+
+	podIdentityMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "pod_identity_mismatches_total",
+		Help:      "The total number of times a wrapped secret was pushed to an init container of another pod.",
+	})
+
+	wrappingTokenRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "wrapping_token_rejections_total",
+		Help:      "The total number of times an init container refused a wrapping token that may have been intercepted.",
+	})
+
+	protocolVersions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "protocol_versions_total",
+		Help:      "The total number of times a protocol version was negotiated with an init container.",
+	},
+		[]string{"version"},
+	)
+
+	wrappedSecretDeletions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "server",
+		Name:      "wrapped_secret_deletions_total",
+		Help:      "The total number of Secrets holding a wrapped secret that were deleted after being consumed or expiring.",
+	})
 )
 
 func init() {
@@ -81,4 +166,15 @@ func init() {
 	prometheus.MustRegister(nodeReaped)
 	prometheus.MustRegister(secretPushes)
 	prometheus.MustRegister(secretPushFailures)
+	prometheus.MustRegister(tokenPushes)
+	prometheus.MustRegister(tokenPushFailures)
+	prometheus.MustRegister(roleIdMismatches)
+	prometheus.MustRegister(roleBindingRejections)
+	prometheus.MustRegister(secretPathRejections)
+	prometheus.MustRegister(appRoleReconciliations)
+	prometheus.MustRegister(appRoleReconciliationFailures)
+	prometheus.MustRegister(podIdentityMismatches)
+	prometheus.MustRegister(wrappingTokenRejections)
+	prometheus.MustRegister(protocolVersions)
+	prometheus.MustRegister(wrappedSecretDeletions)
 }
@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// errIncompatibleInitContainer is the cause of the error returned if the init container cannot use what the pod asked
+// for, so that nothing is issued for it.
+var errIncompatibleInitContainer = errors.New("the init container is not compatible")
+
+// handshake asks the init container at addr which protocol versions and capabilities it supports using GET /. Init
+// containers that predate the handshake respond with 404 or 405 and get common.LegacyHandshake, so that payloads
+// needing any capability are not pushed to them.
+func (s *Store) handshake(ctx context.Context, addr string) (common.Handshake, error) {
+
+	response, err := ctxhttp.Get(ctx, s.httpClient, addr)
+
+	if err != nil {
+		return common.Handshake{}, errors.Wrap(err, "error getting handshake")
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusMethodNotAllowed {
+		io.Copy(ioutil.Discard, response.Body)
+		return common.LegacyHandshake, nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, response.Body)
+		return common.Handshake{}, errors.Errorf("handshake failed with status %s", response.Status)
+	}
+
+	var handshake common.Handshake
+
+	if err := json.NewDecoder(response.Body).Decode(&handshake); err != nil {
+		return common.Handshake{}, errors.Wrap(err, "could not decode handshake")
+	}
+
+	return handshake, nil
+}
+
+// checkCapabilities returns a permanent error if the init container does not have one of the capabilities.
+func checkCapabilities(handshake common.Handshake, capabilities []string) error {
+
+	for _, capability := range capabilities {
+		if !handshake.Supports(capability) {
+			return backoff.Permanent(errors.Wrapf(errIncompatibleInitContainer, "it does not support %s, upgrade its image or check its configuration", capability))
+		}
+	}
+
+	return nil
+}
+
+// podRequiredCapabilities returns the capabilities the init container needs to use what the pod asked for.
+func podRequiredCapabilities(pod client.Pod) []string {
+
+	w := common.WrappedSecretId{
+		Secrets: make([]common.WrappedSecret, len(pod.Secrets)),
+	}
+
+	if pod.TokenRole != "" {
+		w.DeliveryType = common.TokenDelivery
+	} else if len(pod.AppRoles) > 0 {
+		w.DeliveryType = common.AppRolesDelivery
+	}
+
+	return w.RequiredCapabilities()
+}
+
+// checkInitContainer makes sure that the init container of a pod using push delivery can use what the pod asked for,
+// before anything is issued for it.
+func (s *Store) checkInitContainer(pod client.Pod) error {
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = maxHTTPPostTime
+
+	op := func() error {
+
+		ctx, cancel := context.WithTimeout(context.Background(), HTTPPostTimeout)
+		defer cancel()
+
+		handshake, err := s.handshake(ctx, fmt.Sprintf("https://%s:%d", pod.Ip, pod.Port))
+
+		if err != nil {
+			return err
+		}
+
+		if !handshake.Supports(common.CapabilityPodIdentity) {
+			s.logger.Warnf("Init container of pod (%s) does not check that wrapped secrets are intended for its pod. Set POD_NAMESPACE, POD_NAME and POD_UID using the Downward API.", pod.Name)
+		}
+
+		return checkCapabilities(handshake, podRequiredCapabilities(pod))
+	}
+
+	return backoff.Retry(op, exp)
+}
+
+// encodeForInitContainer encodes the wrapped secret using the best protocol version supported by the init container
+// at addr.
+func (s *Store) encodeForInitContainer(ctx context.Context, addr string, wrappedSecret common.WrappedSecretId) ([]byte, error) {
+
+	handshake, err := s.handshake(ctx, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := handshake.NegotiateVersion()
+
+	if err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	if err := checkCapabilities(handshake, wrappedSecret.RequiredCapabilities()); err != nil {
+		return nil, err
+	}
+
+	protocolVersions.WithLabelValues(fmt.Sprint(version)).Inc()
+
+	return common.EncodeMessage(version, wrappedSecret)
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/Boostport/kubernetes-vault/common"
 	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/raft"
@@ -40,6 +41,27 @@ func (s *snapshot) Release() {
 type Config struct {
 	Logger            *logrus.Logger
 	PollPodsFrequency time.Duration
+
+	// AppRoleNamespace is the namespace whose VaultAppRoles are reconciled into Vault. If it is empty, VaultAppRoles
+	// are not reconciled.
+	AppRoleNamespace string
+
+	// EnforceRoleBindings only issues secret_ids for AppRoles the pod is bound to by a VaultRoleBinding.
+	EnforceRoleBindings bool
+
+	// VerifyRoleIds checks that the VAULT_ROLE_ID of the init container matches the pod's AppRole before issuing a
+	// secret_id.
+	VerifyRoleIds bool
+
+	// RoleSecretPaths grants the paths pods may request using the vault-secrets annotation to the roles they use.
+	RoleSecretPaths []RoleSecretPaths
+}
+
+// RoleSecretPaths grants the paths matching any of the patterns to pods using a role. The paths must also be in the
+// vault.allowedSecretPaths setting.
+type RoleSecretPaths struct {
+	Role  string   `mapstructure:"role"`
+	Paths []string `mapstructure:"paths"`
 }
 
 func DefaultStoreConfig() Config {
@@ -64,6 +86,16 @@ type Store struct {
 
 	sync.Mutex
 	pods map[string]client.Pod
+
+	// delivered holds the id of the init container that last received a secret (or was rejected) for each pod, so
+	// that a running native sidecar is not pushed to again unless it is restarted, and an init container using pull
+	// delivery only gets one. It is rebuilt for pods using push delivery when a controller becomes the leader.
+	delivered map[string]string
+
+	// roleBindings caches the valid VaultRoleBindings of each namespace with pods. It is refreshed every time the pods
+	// are polled, and a namespace missing from it is listed when one of its pods needs a secret.
+	roleBindingsLock sync.Mutex
+	roleBindings     client.RoleBindings
 }
 
 func (s *Store) Apply(l *raft.Log) interface{} {
@@ -186,8 +218,14 @@ func (s *Store) start() {
 
 func (s *Store) startLeader() {
 
+	s.rebuildDelivered()
+
 	s.getPodsAndPushSecretIds()
 
+	if s.config.AppRoleNamespace != "" {
+		s.reconcileAppRoles()
+	}
+
 	pollPodsTicker := time.NewTicker(s.config.PollPodsFrequency)
 
 	watchSuccessful := true
@@ -203,16 +241,18 @@ func (s *Store) startLeader() {
 		select {
 		case pod := <-events:
 			s.Lock()
-			if _, ok := s.pods[pod.Name]; !ok {
-				s.pods[pod.Name] = pod
-
-				go s.pushSecretIdToPod(pod)
-			}
+			s.schedulePush(pod)
 			s.Unlock()
 
 		case <-pollPodsTicker.C:
 			s.getPodsAndPushSecretIds()
 
+			if s.config.AppRoleNamespace != "" {
+				s.reconcileAppRoles()
+			}
+
+			s.deleteWrappedSecrets()
+
 			//Try to restart the watcher it it was not started successfully
 			if !watchSuccessful {
 				events, stop, err = s.kubeClient.WatchForPods()
@@ -234,6 +274,63 @@ func (s *Store) startLeader() {
 	}
 }
 
+// rebuildDelivered finds the init containers that already received a secret from the previous leader, which are still
+// running if they are native sidecars, so that a new leader does not push or issue to them again. An init container has
+// received its secret if its /ready endpoint responds with 200. Pods using pull or secret delivery are skipped, as the
+// controller does not connect to them.
+func (s *Store) rebuildDelivered() {
+
+	pods, err := s.kubeClient.GetPods()
+
+	if err != nil {
+		s.logger.Errorf("Could not list pods: %s", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, pod := range pods {
+
+		if pod.Delivery != client.PushDelivery {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(pod client.Pod) {
+			defer wg.Done()
+
+			if !s.isReady(pod) {
+				return
+			}
+
+			s.Lock()
+			s.delivered[pod.Key()] = pod.InitContainerID
+			s.Unlock()
+		}(pod)
+	}
+
+	wg.Wait()
+}
+
+// isReady returns whether the init container of a pod has written its credentials.
+func (s *Store) isReady(pod client.Pod) bool {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := ctxhttp.Get(ctx, s.httpClient, fmt.Sprintf("https://%s:%d/ready", pod.Ip, pod.Port))
+
+	if err != nil {
+		return false
+	}
+
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+
+	return response.StatusCode == http.StatusOK
+}
+
 func (s *Store) getPodsAndPushSecretIds() {
 	pods, err := s.kubeClient.GetPods()
 
@@ -241,32 +338,337 @@ func (s *Store) getPodsAndPushSecretIds() {
 		s.logger.Errorf("Could not list pods: %s", err)
 	}
 
+	if s.config.EnforceRoleBindings {
+		s.refreshRoleBindings(pods)
+	}
+
 	s.Lock()
+
+	current := map[string]bool{}
+
 	for _, pod := range pods {
-		if _, ok := s.pods[pod.Name]; !ok {
-			s.pods[pod.Name] = pod
+		current[pod.Key()] = true
+		s.schedulePush(pod)
+	}
 
-			go s.pushSecretIdToPod(pod)
+	// Forget pods whose init container has exited or that no longer exist
+	if err == nil {
+		for key := range s.delivered {
+			if !current[key] {
+				delete(s.delivered, key)
+			}
 		}
 	}
+
 	s.Unlock()
 }
 
+// schedulePush pushes a secret to the pod, unless a push is already in progress or the pod's current init container
+// already received one. The caller must hold the lock.
+func (s *Store) schedulePush(pod client.Pod) {
+
+	if pod.Delivery == client.PullDelivery {
+		return
+	}
+
+	if _, ok := s.pods[pod.Key()]; ok {
+		return
+	}
+
+	if containerID, ok := s.delivered[pod.Key()]; ok && containerID == pod.InitContainerID {
+		return
+	}
+
+	if _, ok := s.delivered[pod.Key()]; ok {
+		s.logger.Debugf("Init container for pod (%s) was restarted. Pushing a new secret.", pod.Name)
+	}
+
+	s.pods[pod.Key()] = pod
+
+	go s.pushSecretIdToPod(pod)
+}
+
+var (
+	// ErrNotLeader is returned by IssueForPull if this controller is not the leader.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrAlreadyIssued is returned by IssueForPull if the pod's init container already received a wrapped secret.
+	ErrAlreadyIssued = errors.New("a wrapped secret was already issued to the init container")
+)
+
+// IssueForPull gets the wrapped secret for a pod using pull delivery. Only the leader issues them, at most once for
+// each init container, using the same record of delivered init containers as pushes. This way, a replayed service
+// account token cannot be used to get another wrapped secret.
+func (s *Store) IssueForPull(pod client.Pod) (common.WrappedSecretId, error) {
+
+	if s.Raft == nil || s.Raft.State() != raft.Leader {
+		return common.WrappedSecretId{}, ErrNotLeader
+	}
+
+	s.Lock()
+
+	if containerID, ok := s.delivered[pod.Key()]; ok && containerID == pod.InitContainerID {
+		s.Unlock()
+		return common.WrappedSecretId{}, ErrAlreadyIssued
+	}
+
+	s.delivered[pod.Key()] = pod.InitContainerID
+	s.Unlock()
+
+	wrappedSecret, err := s.WrappedSecretForPod(pod)
+
+	if err != nil {
+
+		s.Lock()
+
+		if s.delivered[pod.Key()] == pod.InitContainerID {
+			delete(s.delivered, pod.Key())
+		}
+
+		s.Unlock()
+	}
+
+	return wrappedSecret, err
+}
+
+// WrappedSecretForPod gets the wrapped secret_id(s) or token for a pod, along with the additional secrets it requested.
+// The additional secrets are wrapped first, so that a pod that is not allowed to read them does not cost a secret_id.
+// If anything fails after a wrapping token was issued, it is revoked. If the pod's VAULT_ROLE_ID does not match its
+// AppRole, errRoleIdMismatch is returned.
+func (s *Store) WrappedSecretForPod(pod client.Pod) (common.WrappedSecretId, error) {
+
+	var (
+		wrappedSecret common.WrappedSecretId
+		options       map[string]client.SecretIdOptions
+		err           error
+	)
+
+	options, err = s.authorizeRoleBindings(pod)
+
+	if err != nil {
+		return wrappedSecret, errors.Wrap(err, "could not authorize pod")
+	}
+
+	if pod.TokenRole == "" && len(pod.AppRoles) > 0 {
+
+		if s.config.VerifyRoleIds {
+
+			for _, appRole := range pod.AppRoles {
+
+				if appRole.RoleIDSource == nil {
+					continue
+				}
+
+				err = s.verifyRoleId(pod, common.AppRoleIDEnv(appRole.Name), *appRole.RoleIDSource, appRole.Role)
+
+				if err == errRoleIdMismatch {
+					return wrappedSecret, err
+				}
+
+				if err != nil {
+					return wrappedSecret, errors.Wrapf(err, "could not verify the role_id of AppRole (%s)", appRole.Name)
+				}
+			}
+		}
+
+	} else if pod.TokenRole == "" {
+
+		if s.config.VerifyRoleIds && pod.RoleIDSource != nil {
+
+			err = s.verifyRoleId(pod, client.RoleIDEnv, *pod.RoleIDSource, pod.Role)
+
+			if err == errRoleIdMismatch {
+				return wrappedSecret, err
+			}
+
+			if err != nil {
+				return wrappedSecret, errors.Wrap(err, "could not verify the role_id")
+			}
+		}
+	}
+
+	if err = s.authorizeSecretPaths(pod); err != nil {
+		return wrappedSecret, errors.Wrap(err, "could not authorize pod")
+	}
+
+	secrets := []common.WrappedSecret{}
+
+	for _, secretRequest := range pod.Secrets {
+
+		secret, err := s.vaultClient.GetWrappedSecret(secretRequest)
+
+		if err != nil {
+			s.revokeWrappedSecret(pod, common.WrappedSecretId{Secrets: secrets})
+			return wrappedSecret, errors.Wrapf(err, "could not get secret (%s)", secretRequest.Name)
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	if pod.TokenRole != "" {
+
+		wrappedSecret, err = s.vaultClient.GetToken(pod.TokenRole, options[pod.TokenRole])
+
+		if err != nil {
+			err = errors.Wrapf(err, "could not get token for token role (%s)", pod.TokenRole)
+		}
+
+	} else if len(pod.AppRoles) > 0 {
+
+		wrappedSecret, err = s.vaultClient.GetSecretIds(pod.AppRoles, pod.AppRoleMountPath, options)
+
+		if err != nil {
+			err = errors.Wrap(err, "could not get secret_ids")
+		}
+
+	} else {
+
+		wrappedSecret, err = s.vaultClient.GetSecretId(pod.Role, pod.AppRoleMountPath, options[pod.Role])
+
+		if err != nil {
+			err = errors.Wrapf(err, "could not get secret_id for role (%s)", pod.Role)
+		}
+	}
+
+	if err != nil {
+		s.revokeWrappedSecret(pod, common.WrappedSecretId{Secrets: secrets})
+		return wrappedSecret, err
+	}
+
+	wrappedSecret.Secrets = secrets
+	wrappedSecret.PodNamespace = pod.Namespace
+	wrappedSecret.PodName = pod.Name
+	wrappedSecret.PodUID = pod.UID
+
+	return wrappedSecret, nil
+}
+
+// podRoles returns the AppRoles or token role a pod uses.
+func podRoles(pod client.Pod) []string {
+
+	if pod.TokenRole != "" {
+		return []string{pod.TokenRole}
+	}
+
+	if len(pod.AppRoles) == 0 {
+		return []string{pod.Role}
+	}
+
+	roles := []string{}
+
+	for _, appRole := range pod.AppRoles {
+		roles = append(roles, appRole.Role)
+	}
+
+	return roles
+}
+
+// authorizeSecretPaths checks that every additional secret the pod requested is granted to one of its roles by
+// RoleSecretPaths.
+func (s *Store) authorizeSecretPaths(pod client.Pod) error {
+
+	roles := map[string]bool{}
+
+	for _, role := range podRoles(pod) {
+		roles[role] = true
+	}
+
+	patterns := []string{}
+
+	for _, grant := range s.config.RoleSecretPaths {
+		if roles[grant.Role] {
+			patterns = append(patterns, grant.Paths...)
+		}
+	}
+
+	for _, secretRequest := range pod.Secrets {
+
+		if !client.MatchesSecretPath(patterns, secretRequest.Path) {
+			secretPathRejections.Inc()
+			return errors.Errorf("the path (%s) of secret (%s) is not granted to the pod's roles", secretRequest.Path, secretRequest.Name)
+		}
+	}
+
+	return nil
+}
+
+// revokeWrappedSecret revokes the wrapping tokens of a wrapped secret that will not be delivered to the pod.
+func (s *Store) revokeWrappedSecret(pod client.Pod, wrappedSecret common.WrappedSecretId) {
+
+	if err := s.vaultClient.RevokeWrappedSecret(wrappedSecret); err != nil {
+		s.logger.Errorf("Could not revoke undelivered wrapped secret for pod (%s): %s", pod.Name, err)
+	}
+}
+
 func (s *Store) pushSecretIdToPod(pod client.Pod) {
 
+	delivered := false
+	rejected := false
+	unwrapFailed := false
+
 	// Remove the pod from the list
 	defer func() {
 		s.Lock()
-		delete(s.pods, pod.Name)
+		delete(s.pods, pod.Key())
+
+		if delivered || rejected {
+			s.delivered[pod.Key()] = pod.InitContainerID
+		}
+
 		s.Unlock()
+
+		if unwrapFailed {
+			time.AfterFunc(unwrapFailedRepushDelay, func() {
+				s.repush(pod)
+			})
+		}
 	}()
 
-	s.logger.Debugf("Attempting to push wrapped secret_id to pod (%s).", pod.Name)
+	if pod.TokenRole != "" {
+		s.logger.Debugf("Attempting to push wrapped token to pod (%s).", pod.Name)
+	} else {
+		s.logger.Debugf("Attempting to push wrapped secret_id to pod (%s).", pod.Name)
+	}
+
+	if pod.Delivery == client.PushDelivery {
+
+		if err := s.checkInitContainer(pod); err != nil {
+
+			if errors.Cause(err) == errIncompatibleInitContainer {
+				rejected = true
+				s.reportIncompatibleInitContainer(pod, err)
+				return
+			}
+
+			s.logger.Errorf("Could not reach the init container of pod (%s): %s", pod.Name, err)
+			return
+		}
+	}
+
+	if pod.Delivery == client.SecretDelivery {
+
+		if err := s.kubeClient.CheckWrappedSecret(pod); err != nil {
+
+			if errors.Cause(err) == client.ErrWrappedSecretNotOwned {
+				rejected = true
+				s.reportWrappedSecretConflict(pod, err)
+				return
+			}
+
+			s.logger.Errorf("Could not check the wrapped secret of pod (%s): %s", pod.Name, err)
+			return
+		}
+	}
+
+	wrappedSecret, err := s.WrappedSecretForPod(pod)
 
-	wrappedSecret, err := s.vaultClient.GetSecretId(pod.Role)
+	if err == errRoleIdMismatch {
+		rejected = true
+		return
+	}
 
 	if err != nil {
-		s.logger.Errorf("Could not get secret_id for role (%s) for pod (%s): %s", pod.Role, pod.Name, err)
+		s.logger.Errorf("Could not get wrapped secret for pod (%s): %s", pod.Name, err)
 		return
 	}
 
@@ -274,18 +676,33 @@ func (s *Store) pushSecretIdToPod(pod client.Pod) {
 
 	if err != nil {
 		s.logger.Errorf("Could not marshal wrapped secret to JSON: %s", err)
+		s.revokeWrappedSecret(pod, wrappedSecret)
 		return
 	}
 
 	exp := backoff.NewExponentialBackOff()
 	exp.MaxElapsedTime = maxHTTPPostTime
 
-	// POST the secret with backoff
+	ip := pod.Ip
+
+	// POST the secret, or write it to a Secret, with backoff
 	op := func() error {
 
+		if pod.Delivery == client.SecretDelivery {
+			return s.kubeClient.WriteWrappedSecret(pod, b, wrappedSecret.Expiration())
+		}
+
 		ctx, _ := context.WithTimeout(context.Background(), HTTPPostTimeout)
 
-		response, err := ctxhttp.Post(ctx, s.httpClient, fmt.Sprintf("https://%s:%d", pod.Ip, pod.Port), "application/json", bytes.NewReader(b))
+		addr := fmt.Sprintf("https://%s:%d", ip, pod.Port)
+
+		message, err := s.encodeForInitContainer(ctx, addr, wrappedSecret)
+
+		if err != nil {
+			return err
+		}
+
+		response, err := ctxhttp.Post(ctx, s.httpClient, addr, "application/json", bytes.NewReader(message))
 
 		if err != nil {
 			return errors.Wrap(err, "error POSTing wrapped token")
@@ -293,24 +710,192 @@ func (s *Store) pushSecretIdToPod(pod client.Pod) {
 
 		defer response.Body.Close()
 
+		var errorResponse common.ErrorResponse
+
+		if response.StatusCode >= http.StatusBadRequest {
+			json.NewDecoder(response.Body).Decode(&errorResponse)
+		}
+
 		io.Copy(ioutil.Discard, response.Body)
 
+		switch errorResponse.Code {
+		case common.PodIdentityMismatch:
+			return s.resolvePodIp(pod, &ip, errorResponse.Message)
+
+		case common.UnsupportedVersion:
+			return backoff.Permanent(errors.Errorf("init container does not support the protocol version: %s", errorResponse.Message))
+
+		case common.UnwrapFailed:
+			return backoff.Permanent(errors.Wrapf(errUnwrapFailed, "a new one will be pushed: %s", errorResponse.Message))
+
+		case common.WrappingTokenInvalid:
+			s.reportPossibleInterception(pod, errorResponse.Message)
+			return backoff.Permanent(errors.Errorf("init container refused the wrapping token: %s", errorResponse.Message))
+		}
+
+		if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+			return errors.Errorf("init container responded with %s", response.Status)
+		}
+
 		return nil
 	}
 
 	err = backoff.Retry(op, exp)
-	secretPushes.With(prometheus.Labels{"approle": pod.Role}).Inc()
+
+	if errors.Cause(err) == errUnwrapFailed {
+		unwrapFailed = true
+	}
+
+	if errors.Cause(err) == client.ErrWrappedSecretNotOwned {
+		rejected = true
+		s.reportWrappedSecretConflict(pod, err)
+	}
+
+	// The init container used up or discarded a wrapped secret it could not unwrap. Any other wrapped secret that was
+	// not delivered is revoked, as a new one is issued for the next push.
+	if err != nil && !unwrapFailed {
+		s.revokeWrappedSecret(pod, wrappedSecret)
+	}
+
+	if pod.TokenRole != "" {
+		tokenPushes.With(prometheus.Labels{"token_role": pod.TokenRole}).Inc()
+
+		if err != nil {
+			tokenPushFailures.With(prometheus.Labels{"token_role": pod.TokenRole}).Inc()
+			s.logger.Errorf("Could not push wrapped token to pod (%s): %s", pod.Name, err)
+		} else {
+			delivered = true
+			s.logger.Debugf("Successfully pushed wrapped token to pod (%s)", pod.Name)
+		}
+
+		return
+	}
+
+	roles := []string{pod.Role}
+
+	if len(pod.AppRoles) > 0 {
+		roles = []string{}
+
+		for _, appRole := range pod.AppRoles {
+			roles = append(roles, appRole.Role)
+		}
+	}
+
+	for _, role := range roles {
+		secretPushes.With(prometheus.Labels{"approle": role}).Inc()
+
+		if err != nil {
+			secretPushFailures.With(prometheus.Labels{"approle": role}).Inc()
+		}
+	}
 
 	if err != nil {
-		secretPushFailures.With(prometheus.Labels{"approle": pod.Role}).Inc()
 		s.logger.Errorf("Could not push wrapped secret_id to pod (%s): %s", pod.Name, err)
 	} else {
+		delivered = true
 		s.logger.Debugf("Successfully pushed wrapped secret_id to pod (%s)", pod.Name)
 	}
 
 	return
 }
 
+// errUnwrapFailed is the cause of the error returned if the init container could not unwrap a pushed wrapped secret.
+var errUnwrapFailed = errors.New("init container could not unwrap the wrapped secret")
+
+// repush pushes a new wrapped secret to a pod whose init container could not unwrap the last one. Nothing else
+// schedules the push, as the pod does not change. The pod is looked up again, so that pods that are gone are skipped.
+func (s *Store) repush(pod client.Pod) {
+
+	if s.Raft == nil || s.Raft.State() != raft.Leader {
+		return
+	}
+
+	current, err := s.kubeClient.GetPod(pod.Namespace, pod.Name)
+
+	if err != nil {
+		s.logger.Debugf("Not pushing a new wrapped secret to pod (%s): %s", pod.Name, err)
+		return
+	}
+
+	if current.UID != pod.UID {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.schedulePush(current)
+}
+
+// resolvePodIp is called when the init container at ip is not the pod's, because the ip was reused. It looks up the
+// pod's current ip, so that the push is retried against the right pod. If the pod is gone, the push is aborted.
+func (s *Store) resolvePodIp(pod client.Pod, ip *string, message string) error {
+
+	podIdentityMismatches.Inc()
+
+	s.logger.Errorf("Init container at ip (%s) rejected the wrapped secret for pod (%s): %s", *ip, pod.Name, message)
+
+	current, err := s.kubeClient.GetPod(pod.Namespace, pod.Name)
+
+	if err != nil {
+		return backoff.Permanent(errors.Wrap(err, "could not look up the pod again"))
+	}
+
+	if current.UID != pod.UID {
+		return backoff.Permanent(errors.New("the pod was replaced"))
+	}
+
+	*ip = current.Ip
+
+	return errors.Errorf("pod identity mismatch, retrying against ip (%s)", current.Ip)
+}
+
+// reportPossibleInterception records that the init container refused a wrapping token because it was not created for
+// the expected path at the expected time, which means that it may have been substituted.
+func (s *Store) reportPossibleInterception(pod client.Pod, message string) {
+
+	wrappingTokenRejections.Inc()
+
+	s.logger.Errorf("Init container of pod (%s) refused a wrapping token, it may have been intercepted: %s", pod.Name, message)
+
+	if err := s.kubeClient.CreateEvent(pod, "Warning", "PossibleInterception", "The init container refused a wrapping token that may have been intercepted: "+message); err != nil {
+		s.logger.Errorf("Could not record possible interception for pod (%s): %s", pod.Name, err)
+	}
+}
+
+// reportIncompatibleInitContainer records that nothing was issued for a pod because its init container cannot use it.
+func (s *Store) reportIncompatibleInitContainer(pod client.Pod, reason error) {
+
+	s.logger.Errorf("Not pushing to pod (%s): %s", pod.Name, reason)
+
+	if err := s.kubeClient.CreateEvent(pod, "Warning", "IncompatibleInitContainer", fmt.Sprintf("No secrets were pushed: %s.", reason)); err != nil {
+		s.logger.Errorf("Could not record incompatible init container for pod (%s): %s", pod.Name, err)
+	}
+}
+
+// reportWrappedSecretConflict records that nothing was written for a pod using secret delivery because the Secret its
+// wrapped secret is written to belongs to something else.
+func (s *Store) reportWrappedSecretConflict(pod client.Pod, reason error) {
+
+	s.logger.Errorf("Not writing the wrapped secret of pod (%s): %s", pod.Name, reason)
+
+	if err := s.kubeClient.CreateEvent(pod, "Warning", "WrappedSecretConflict", fmt.Sprintf("No secrets were written: %s.", reason)); err != nil {
+		s.logger.Errorf("Could not record wrapped secret conflict for pod (%s): %s", pod.Name, err)
+	}
+}
+
+// deleteWrappedSecrets deletes the Secrets written for pods using secret delivery that were consumed or expired.
+func (s *Store) deleteWrappedSecrets() {
+
+	deleted, err := s.kubeClient.DeleteWrappedSecrets()
+
+	wrappedSecretDeletions.Add(float64(deleted))
+
+	if err != nil {
+		s.logger.Errorf("Could not delete wrapped secrets: %s", err)
+	}
+}
+
 func (s *Store) handleGossipMembershipChange(memberEvent serf.MemberEvent) {
 	peers, err := s.peerStore.Peers()
 
@@ -384,6 +969,121 @@ func (s *Store) Shutdown() {
 	close(s.shutdown)
 }
 
+// authorizeRoleBindings checks that the pod's VaultRoleBindings bind it to its roles and secret paths if they are
+// enforced and returns the secret_id options for each AppRole.
+func (s *Store) authorizeRoleBindings(pod client.Pod) (map[string]client.SecretIdOptions, error) {
+
+	if !s.config.EnforceRoleBindings {
+		return map[string]client.SecretIdOptions{}, nil
+	}
+
+	s.roleBindingsLock.Lock()
+	_, ok := s.roleBindings[pod.Namespace]
+	s.roleBindingsLock.Unlock()
+
+	if !ok {
+		if err := s.refreshNamespaceRoleBindings(pod.Namespace); err != nil {
+			return nil, errors.Wrap(err, "could not list VaultRoleBindings")
+		}
+	}
+
+	s.roleBindingsLock.Lock()
+	options, err := s.roleBindings.AuthorizePod(pod)
+	s.roleBindingsLock.Unlock()
+
+	if err != nil {
+		roleBindingRejections.Inc()
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// refreshRoleBindings lists the VaultRoleBindings of every namespace with pods, updating their status, and replaces the
+// cached bindings. The bindings of a namespace that could not be listed are dropped, so that they are listed again when
+// one of its pods needs a secret.
+func (s *Store) refreshRoleBindings(pods []client.Pod) {
+
+	roleBindings := client.RoleBindings{}
+
+	for _, pod := range pods {
+
+		if _, ok := roleBindings[pod.Namespace]; ok {
+			continue
+		}
+
+		bindings, err := s.kubeClient.RefreshVaultRoleBindings(pod.Namespace)
+
+		if err != nil {
+			s.logger.Errorf("Could not list VaultRoleBindings in namespace (%s): %s", pod.Namespace, err)
+			continue
+		}
+
+		roleBindings[pod.Namespace] = bindings
+	}
+
+	s.roleBindingsLock.Lock()
+	s.roleBindings = roleBindings
+	s.roleBindingsLock.Unlock()
+}
+
+// refreshNamespaceRoleBindings lists the VaultRoleBindings of a namespace, updating their status, and caches them.
+func (s *Store) refreshNamespaceRoleBindings(namespace string) error {
+
+	bindings, err := s.kubeClient.RefreshVaultRoleBindings(namespace)
+
+	if err != nil {
+		return err
+	}
+
+	s.roleBindingsLock.Lock()
+
+	if s.roleBindings == nil {
+		s.roleBindings = client.RoleBindings{}
+	}
+
+	s.roleBindings[namespace] = bindings
+	s.roleBindingsLock.Unlock()
+
+	return nil
+}
+
+var errRoleIdMismatch = errors.New("role_id mismatch")
+
+// verifyRoleId compares an environment variable of the pod's init container with the role_id of an AppRole, so that a
+// typo is reported before a secret_id is spent. On a mismatch, an event is recorded for the pod and errRoleIdMismatch
+// is returned.
+func (s *Store) verifyRoleId(pod client.Pod, env string, source client.EnvSource, role string) error {
+
+	podRoleId, err := s.kubeClient.ResolveEnv(pod.Namespace, source)
+
+	if err != nil {
+		return errors.Wrapf(err, "could not resolve %s", env)
+	}
+
+	roleId, err := s.vaultClient.GetRoleId(role, pod.AppRoleMountPath)
+
+	if err != nil {
+		return err
+	}
+
+	if podRoleId == roleId {
+		return nil
+	}
+
+	roleIdMismatches.With(prometheus.Labels{"approle": role}).Inc()
+
+	message := fmt.Sprintf("The %s environment variable of the init container does not match the role_id of the AppRole (%s). No secret_id was pushed.", env, role)
+
+	s.logger.Errorf("Pod (%s): %s", pod.Name, message)
+
+	if err := s.kubeClient.CreateEvent(pod, "Warning", "RoleIdMismatch", message); err != nil {
+		s.logger.Errorf("Could not record role_id mismatch for pod (%s): %s", pod.Name, err)
+	}
+
+	return errRoleIdMismatch
+}
+
 func NewStore(gossip *Gossip, kubeClient *client.Kube, vaultClient *client.Vault, config Config) *Store {
 
 	// We need to skip TLS verification because the init container uses a self-signed and short-lived certificate
@@ -400,5 +1100,6 @@ func NewStore(gossip *Gossip, kubeClient *client.Kube, vaultClient *client.Vault
 		logger:      config.Logger,
 		shutdown:    make(chan struct{}),
 		pods:        map[string]client.Pod{},
+		delivered:   map[string]string{},
 	}
 }
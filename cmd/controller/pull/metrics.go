@@ -0,0 +1,24 @@
+package pull
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	requests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "pull",
+		Name:      "requests_total",
+		Help:      "The total number of requests for wrapped secrets made by init containers.",
+	})
+
+	requestFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "pull",
+		Name:      "request_failures_total",
+		Help:      "The total number of requests for wrapped secrets that were rejected or failed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requests)
+	prometheus.MustRegister(requestFailures)
+}
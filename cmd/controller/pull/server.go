@@ -0,0 +1,170 @@
+package pull
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/Boostport/kubernetes-vault/cmd/controller/cluster"
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/sirupsen/logrus"
+)
+
+// SecretSource issues the wrapped secret for a pod using pull delivery.
+type SecretSource interface {
+	IssueForPull(pod client.Pod) (common.WrappedSecretId, error)
+}
+
+type Config struct {
+	Addr string
+
+	// Audience is the audience service account tokens must be issued for.
+	Audience string
+
+	Kube    *client.Kube
+	Secrets SecretSource
+	Logger  *logrus.Logger
+}
+
+type Server struct {
+	config        Config
+	certificateCh <-chan tls.Certificate
+
+	sync.Mutex
+	certificate *tls.Certificate
+}
+
+func (s *Server) getCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.certificate, nil
+}
+
+func (s *Server) watchForNewCertificates() {
+	for cert := range s.certificateCh {
+		cert := cert
+
+		s.Lock()
+		s.certificate = &cert
+		s.Unlock()
+	}
+}
+
+// handleSecret authenticates the init container using the service account token in the Authorization header, resolves
+// the pod the token was issued to and responds with the pod's wrapped secret.
+func (s *Server) handleSecret(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests.Inc()
+
+	authorization := req.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+
+	if !strings.HasPrefix(authorization, "Bearer ") || token == "" {
+		requestFailures.Inc()
+		http.Error(w, "A service account token is required in the Authorization header.", http.StatusUnauthorized)
+		return
+	}
+
+	identity, err := s.config.Kube.ReviewToken(token, s.config.Audience)
+
+	if err != nil {
+		requestFailures.Inc()
+		s.config.Logger.Debugf("Rejected pull request: %s", err)
+		http.Error(w, "Invalid service account token.", http.StatusUnauthorized)
+		return
+	}
+
+	pod, err := s.config.Kube.GetPod(identity.Namespace, identity.PodName)
+
+	if err != nil {
+		requestFailures.Inc()
+		s.config.Logger.Debugf("Rejected pull request from pod (%s): %s", identity.PodName, err)
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+
+	if pod.UID != identity.PodUID || pod.ServiceAccount != identity.ServiceAccount {
+		requestFailures.Inc()
+		s.config.Logger.Debugf("Rejected pull request from pod (%s): the token was issued to a different pod or service account", pod.Name)
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+
+	if pod.Delivery != client.PullDelivery {
+		requestFailures.Inc()
+		s.config.Logger.Debugf("Rejected pull request from pod (%s): the pod does not use pull delivery", pod.Name)
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+
+	wrappedSecret, err := s.config.Secrets.IssueForPull(pod)
+
+	switch {
+	case err == cluster.ErrNotLeader:
+		http.Error(w, "Not the leader. Try again.", http.StatusServiceUnavailable)
+		return
+
+	case err == cluster.ErrAlreadyIssued:
+		requestFailures.Inc()
+		s.config.Logger.Errorf("Rejected pull request from pod (%s): %s", pod.Name, err)
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+
+	case err != nil:
+		requestFailures.Inc()
+		s.config.Logger.Errorf("Could not get wrapped secret for pod (%s): %s", pod.Name, err)
+		http.Error(w, "Could not get wrapped secret.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(wrappedSecret); err != nil {
+		requestFailures.Inc()
+		s.config.Logger.Errorf("Could not write wrapped secret to pod (%s): %s", pod.Name, err)
+		return
+	}
+
+	s.config.Logger.Debugf("Successfully delivered wrapped secret to pod (%s) using pull delivery", pod.Name)
+}
+
+func (s *Server) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(common.PullPath, s.handleSecret)
+
+	server := &http.Server{
+		Addr:    s.config.Addr,
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			GetCertificate: s.getCertificate,
+		},
+	}
+
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		s.config.Logger.Fatalf("Could not start pull server: %s", err)
+	}
+}
+
+// StartServer serves wrapped secrets to init containers using pull delivery over https using the certificates
+// received from certificateCh.
+func StartServer(certificateCh <-chan tls.Certificate, config Config) {
+
+	server := &Server{
+		config:        config,
+		certificateCh: certificateCh,
+	}
+
+	go server.watchForNewCertificates()
+
+	go server.start()
+}
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
+	"path"
 	"strings"
 	"syscall"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
 	"github.com/Boostport/kubernetes-vault/cmd/controller/cluster"
 	"github.com/Boostport/kubernetes-vault/cmd/controller/metrics"
+	"github.com/Boostport/kubernetes-vault/cmd/controller/pull"
+	"github.com/Boostport/kubernetes-vault/cmd/controller/webhook"
 	"github.com/Boostport/kubernetes-vault/common"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -22,7 +25,11 @@ import (
 	"github.com/spf13/viper"
 )
 
-const defaultWrappingTTL = "60s"
+const (
+	defaultWrappingTTL     = "60s"
+	defaultCredentialsPath = "/var/run/secrets/boostport.com"
+	defaultPullAudience    = "kubernetes-vault"
+)
 
 func init() {
 	RootCmd.Flags().String("config", "", "Path to the configuration file. By default, this is kubernetes-vault.yml in the current working directory.")
@@ -33,22 +40,53 @@ type config struct {
 	RaftDir string `mapstructure:"raftDir"`
 
 	Vault struct {
-		Addr  string `mapstructure:"addr"`
-		Token string `mapstructure:"token"`
-		SkipTokenRoleNameValidation bool `mapstructure:"skipTokenRoleNameValidation"`
-		TLS   struct {
+		Addr                        string `mapstructure:"addr"`
+		Token                       string `mapstructure:"token"`
+		SkipTokenRoleNameValidation bool   `mapstructure:"skipTokenRoleNameValidation"`
+		TLS                         struct {
 			VaultCABackends []string `mapstructure:"vaultCABackends"`
 			CACert          string   `mapstructure:"caCert"`
 		} `mapstructure:"tls"`
-		WrappingTTL string `mapstructure:"wrappingTTL"`
+		WrappingTTL              string                    `mapstructure:"wrappingTTL"`
+		AppRoleMountPath         string                    `mapstructure:"appRoleMountPath"`
+		AllowedAppRoleMountPaths []string                  `mapstructure:"allowedAppRoleMountPaths"`
+		AllowedSecretPaths       []string                  `mapstructure:"allowedSecretPaths"`
+		RoleSecretPaths          []cluster.RoleSecretPaths `mapstructure:"roleSecretPaths"`
+		SupplyRoleIds            bool                      `mapstructure:"supplyRoleIds"`
+		VerifyRoleIds            bool                      `mapstructure:"verifyRoleIds"`
 	} `mapstructure:"vault"`
 
 	Kubernetes struct {
-		WatchNamespace   string `mapstructure:"watchNamespace"`
-		ServiceNamespace string `mapstructure:"serviceNamespace"`
-		Service          string `mapstructure:"service"`
+		WatchNamespace              string   `mapstructure:"watchNamespace"`
+		ServiceNamespace            string   `mapstructure:"serviceNamespace"`
+		Service                     string   `mapstructure:"service"`
+		AllowedInitContainerImages  []string `mapstructure:"allowedInitContainerImages"`
+		AllowedInitContainerDigests []string `mapstructure:"allowedInitContainerDigests"`
+		EnforceRoleBindings         bool     `mapstructure:"enforceRoleBindings"`
+		ReconcileAppRoles           bool     `mapstructure:"reconcileAppRoles"`
 	} `mapstructure:"kubernetes"`
 
+	Webhook struct {
+		Addr               string                   `mapstructure:"addr"`
+		InitContainerImage string                   `mapstructure:"initContainerImage"`
+		CredentialsPath    string                   `mapstructure:"credentialsPath"`
+		RoleNamespaces     []webhook.RoleNamespaces `mapstructure:"roleNamespaces"`
+		VerifyRolesExist   bool                     `mapstructure:"verifyRolesExist"`
+		TLS                struct {
+			CertFile string `mapstructure:"certFile"`
+			CertKey  string `mapstructure:"certKey"`
+		} `mapstructure:"tls"`
+	} `mapstructure:"webhook"`
+
+	Pull struct {
+		Addr     string `mapstructure:"addr"`
+		Audience string `mapstructure:"audience"`
+		TLS      struct {
+			CertFile string `mapstructure:"certFile"`
+			CertKey  string `mapstructure:"certKey"`
+		} `mapstructure:"tls"`
+	} `mapstructure:"pull"`
+
 	Prometheus struct {
 		TLS struct {
 			VaultCertBackend string   `mapstructure:"vaultCertBackend"`
@@ -88,6 +126,29 @@ func (c *config) Validate() error {
 		errs = multierror.Append(errs, errors.New("vault.token is required"))
 	}
 
+	if client.NormalizeAppRoleMountPath(c.Vault.AppRoleMountPath) == "" {
+		errs = multierror.Append(errs, errors.New("vault.appRoleMountPath cannot be empty"))
+	}
+
+	for _, pattern := range c.Vault.AllowedSecretPaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = multierror.Append(errs, errors.Errorf("vault.allowedSecretPaths contains an invalid pattern (%s)", pattern))
+		}
+	}
+
+	for _, roleSecretPaths := range c.Vault.RoleSecretPaths {
+
+		if roleSecretPaths.Role == "" {
+			errs = multierror.Append(errs, errors.New("vault.roleSecretPaths contains an entry without a role"))
+		}
+
+		for _, pattern := range roleSecretPaths.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = multierror.Append(errs, errors.Errorf("vault.roleSecretPaths contains an invalid pattern (%s) for the role (%s)", pattern, roleSecretPaths.Role))
+			}
+		}
+	}
+
 	if len(c.Vault.TLS.VaultCABackends) > 0 && c.Vault.TLS.CACert != "" {
 		errs = multierror.Append(errs, errors.New(`Contraditory Vault TLS configuration. You must use either Vault CA backends (vault.tls.vaultCABackends) or your own Root CA file (vault.tls.caCertFilePath) to verify the Vault server TLS certificate, not both.`))
 	}
@@ -104,6 +165,38 @@ func (c *config) Validate() error {
 		errs = multierror.Append(errs, errors.New("kubernetes.service is required"))
 	}
 
+	if c.Webhook.Addr != "" {
+
+		if c.Webhook.TLS.CertFile == "" || c.Webhook.TLS.CertKey == "" {
+			errs = multierror.Append(errs, errors.New("webhook.tls.certFile and webhook.tls.certKey are required to serve the webhook"))
+		}
+
+		if !isPinnedImage(c.Webhook.InitContainerImage) {
+			errs = multierror.Append(errs, errors.New("webhook.initContainerImage is required and must have a tag other than latest or a digest"))
+		}
+
+		for _, roleNamespaces := range c.Webhook.RoleNamespaces {
+
+			if roleNamespaces.Role == "" {
+				errs = multierror.Append(errs, errors.New("webhook.roleNamespaces contains an entry without a role"))
+			}
+
+			for _, namespace := range roleNamespaces.Namespaces {
+				if _, err := client.NamespaceRegex(namespace); err != nil {
+					errs = multierror.Append(errs, errors.Errorf("webhook.roleNamespaces contains an invalid namespace (%s) for the role (%s)", namespace, roleNamespaces.Role))
+				}
+			}
+		}
+	}
+
+	if c.Pull.Addr != "" && (c.Pull.TLS.CertFile == "" || c.Pull.TLS.CertKey == "") {
+		errs = multierror.Append(errs, errors.New("pull.tls.certFile and pull.tls.certKey are required to serve pull delivery"))
+	}
+
+	if c.Pull.Addr != "" && c.Pull.Audience == "" {
+		errs = multierror.Append(errs, errors.New("pull.audience cannot be empty"))
+	}
+
 	if len(c.Prometheus.TLS.VaultCABackends) > 0 && c.Prometheus.TLS.CACert != "" {
 		errs = multierror.Append(errs, errors.New(`Contraditory Prometheus TLS configuration. You use either Vault CA backends (prometheus.tls.vaultCABackends) or your own Root CA file (prometheus.tls.caCertFilePath) to verify the Prometheus scraper TLS certificate, not both.`))
 	}
@@ -124,10 +217,30 @@ func newConfigWithDefaults() *config {
 	}
 
 	cfg.Vault.WrappingTTL = defaultWrappingTTL
+	cfg.Vault.AppRoleMountPath = common.DefaultAppRoleMountPath
+	cfg.Webhook.CredentialsPath = defaultCredentialsPath
+	cfg.Pull.Audience = defaultPullAudience
 
 	return cfg
 }
 
+// isPinnedImage returns whether an image refers to a specific version, so that injected init containers do not change
+// when a tag is moved.
+func isPinnedImage(image string) bool {
+
+	if strings.Contains(image, "@sha256:") {
+		return true
+	}
+
+	i := strings.LastIndex(image, ":")
+
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return false
+	}
+
+	return image[i+1:] != "latest"
+}
+
 func certificateFromFile(certFile string, keyFile string) (<-chan tls.Certificate, error) {
 
 	ch := make(chan tls.Certificate, 1)
@@ -206,7 +319,7 @@ var RootCmd = &cobra.Command{
 			logger.Fatalf("Could not determine external ip address: %s", err)
 		}
 
-		kube, err := client.NewKube(conf.Kubernetes.WatchNamespace, logger)
+		kube, err := client.NewKube(conf.Kubernetes.WatchNamespace, conf.Kubernetes.AllowedInitContainerImages, conf.Kubernetes.AllowedInitContainerDigests, logger)
 
 		if err != nil {
 			logger.Fatalf("Could not create the kubernetes client: %s", err)
@@ -239,7 +352,7 @@ var RootCmd = &cobra.Command{
 			}
 		}
 
-		vault, err := client.NewVault(conf.Vault.Addr, conf.Vault.Token, conf.Vault.SkipTokenRoleNameValidation, conf.Kubernetes.Service, conf.Vault.WrappingTTL, rootCAResolver, logger)
+		vault, err := client.NewVault(conf.Vault.Addr, conf.Vault.Token, conf.Vault.SkipTokenRoleNameValidation, conf.Kubernetes.Service, conf.Vault.WrappingTTL, conf.Vault.AppRoleMountPath, conf.Vault.AllowedAppRoleMountPaths, conf.Vault.AllowedSecretPaths, conf.Vault.SupplyRoleIds, rootCAResolver, logger)
 
 		if err != nil {
 			logger.Fatalf("Could not create the vault client: %s", err)
@@ -289,6 +402,40 @@ var RootCmd = &cobra.Command{
 
 		metrics.StartServer(certCh, roots)
 
+		if conf.Webhook.Addr != "" {
+
+			webhookCertCh, err := certificateFromFile(conf.Webhook.TLS.CertFile, conf.Webhook.TLS.CertKey)
+
+			if err != nil {
+				logger.Fatalf("Could not load certificate for webhook server: %s", err)
+			}
+
+			webhookConfig := webhook.Config{
+				Addr:               conf.Webhook.Addr,
+				InitContainerImage: conf.Webhook.InitContainerImage,
+				CredentialsPath:    conf.Webhook.CredentialsPath,
+				RoleNamespaces:     conf.Webhook.RoleNamespaces,
+				SupplyRoleIds:      conf.Vault.SupplyRoleIds,
+				AllowedSecretPaths: conf.Vault.AllowedSecretPaths,
+				RoleSecretPaths:    map[string][]string{},
+				Logger:             logger,
+			}
+
+			for _, roleSecretPaths := range conf.Vault.RoleSecretPaths {
+				webhookConfig.RoleSecretPaths[roleSecretPaths.Role] = append(webhookConfig.RoleSecretPaths[roleSecretPaths.Role], roleSecretPaths.Paths...)
+			}
+
+			if conf.Webhook.VerifyRolesExist {
+				webhookConfig.Vault = vault
+			}
+
+			err = webhook.StartServer(webhookCertCh, webhookConfig)
+
+			if err != nil {
+				logger.Fatalf("Could not start webhook server: %s", err)
+			}
+		}
+
 		gossip, err := cluster.NewGossip(bindAddr.String(), nodes, 0, logger.WriterLevel(logrus.DebugLevel))
 
 		if err != nil {
@@ -297,6 +444,13 @@ var RootCmd = &cobra.Command{
 
 		storeConfig := cluster.DefaultStoreConfig()
 		storeConfig.Logger = logger
+		storeConfig.VerifyRoleIds = conf.Vault.VerifyRoleIds
+		storeConfig.RoleSecretPaths = conf.Vault.RoleSecretPaths
+		storeConfig.EnforceRoleBindings = conf.Kubernetes.EnforceRoleBindings
+
+		if conf.Kubernetes.ReconcileAppRoles {
+			storeConfig.AppRoleNamespace = conf.Kubernetes.ServiceNamespace
+		}
 
 		store := cluster.NewStore(gossip, kube, vault, storeConfig)
 
@@ -306,6 +460,23 @@ var RootCmd = &cobra.Command{
 			logger.Fatalf("Could not start raft: %s", err)
 		}
 
+		if conf.Pull.Addr != "" {
+
+			pullCertCh, err := certificateFromFile(conf.Pull.TLS.CertFile, conf.Pull.TLS.CertKey)
+
+			if err != nil {
+				logger.Fatalf("Could not load certificate for pull server: %s", err)
+			}
+
+			pull.StartServer(pullCertCh, pull.Config{
+				Addr:     conf.Pull.Addr,
+				Audience: conf.Pull.Audience,
+				Kube:     kube,
+				Secrets:  store,
+				Logger:   logger,
+			})
+		}
+
 		sigs := make(chan os.Signal, 1)
 		done := make(chan struct{}, 1)
 
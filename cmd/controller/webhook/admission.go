@@ -0,0 +1,87 @@
+package webhook
+
+import "encoding/json"
+
+// The admission.k8s.io types are not part of the kubernetes client we use, so only the fields we need are declared.
+
+type admissionReview struct {
+	ApiVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Namespace string          `json:"namespace"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+	OldObject json.RawMessage `json:"oldObject"`
+}
+
+type admissionResponse struct {
+	UID       string  `json:"uid"`
+	Allowed   bool    `json:"allowed"`
+	Patch     []byte  `json:"patch,omitempty"`
+	PatchType string  `json:"patchType,omitempty"`
+	Result    *status `json:"status,omitempty"`
+}
+
+type status struct {
+	Message string `json:"message"`
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type pod struct {
+	Metadata struct {
+		Name         string            `json:"name"`
+		GenerateName string            `json:"generateName"`
+		Annotations  map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		InitContainers []container `json:"initContainers"`
+		Containers     []container `json:"containers"`
+		Volumes        []volume    `json:"volumes"`
+	} `json:"spec"`
+}
+
+type container struct {
+	Name         string        `json:"name"`
+	Image        string        `json:"image,omitempty"`
+	Env          []envVar      `json:"env,omitempty"`
+	VolumeMounts []volumeMount `json:"volumeMounts,omitempty"`
+}
+
+type envVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *envVarSource `json:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	FieldRef *objectFieldSelector `json:"fieldRef,omitempty"`
+}
+
+type objectFieldSelector struct {
+	FieldPath string `json:"fieldPath"`
+}
+
+type volume struct {
+	Name     string    `json:"name"`
+	EmptyDir *emptyDir `json:"emptyDir,omitempty"`
+}
+
+type emptyDir struct {
+	Medium string `json:"medium,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
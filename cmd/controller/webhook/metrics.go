@@ -0,0 +1,40 @@
+package webhook
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	injections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "webhook",
+		Name:      "injections_total",
+		Help:      "The total number of pods the init container was injected into.",
+	})
+
+	injectionFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "webhook",
+		Name:      "injection_failures_total",
+		Help:      "The total number of pods that were rejected because the init container could not be injected.",
+	})
+
+	validations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "webhook",
+		Name:      "validations_total",
+		Help:      "The total number of pods validated.",
+	})
+
+	validationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubernetesvault",
+		Subsystem: "webhook",
+		Name:      "validation_failures_total",
+		Help:      "The total number of pods that were rejected because their vault annotations are invalid.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(injections)
+	prometheus.MustRegister(injectionFailures)
+	prometheus.MustRegister(validations)
+	prometheus.MustRegister(validationFailures)
+}
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	InjectContainersAnnotation = "pod.boostport.com/vault-inject-containers"
+
+	initContainerName     = "kubernetes-vault-init"
+	credentialsVolumeName = "vault-credentials"
+)
+
+// mutate returns the JSON patch that injects the init container, the credentials volume and the volume mounts into
+// a pod. Pods without a Vault role annotation, or that already name their own init container, are not changed.
+func (s *Server) mutate(object json.RawMessage) ([]patchOperation, error) {
+
+	var p pod
+
+	if err := json.Unmarshal(object, &p); err != nil {
+		return nil, errors.Wrap(err, "could not decode pod")
+	}
+
+	annotations := p.Metadata.Annotations
+
+	_, hasRole := annotations[client.RoleAnnotation]
+	_, hasTokenRole := annotations[client.TokenRoleAnnotation]
+	_, hasAppRoles := annotations[client.AppRolesAnnotation]
+	_, hasInitContainer := annotations[client.InitContainerAnnotation]
+
+	if !(hasRole || hasTokenRole || hasAppRoles) || hasInitContainer {
+		return nil, nil
+	}
+
+	if (hasRole || hasAppRoles) && !s.config.SupplyRoleIds {
+		return nil, errors.Errorf("the init container cannot be injected for AppRoles unless vault.supplyRoleIds is enabled, because it does not have %s set. Add your own init container and set the %s annotation", client.RoleIDEnv, client.InitContainerAnnotation)
+	}
+
+	// The init container needs the controller's address to pull, which the webhook does not know.
+	if annotations[client.DeliveryAnnotation] == client.PullDelivery {
+		return nil, errors.Errorf("the init container cannot be injected for %s delivery, because it needs CONTROLLER_ADDR and a service account token for the controller. Add your own init container and set the %s annotation", client.PullDelivery, client.InitContainerAnnotation)
+	}
+
+	for _, c := range p.Spec.InitContainers {
+		if c.Name == initContainerName {
+			return nil, errors.Errorf("pod already has an init container named %s", initContainerName)
+		}
+	}
+
+	for _, v := range p.Spec.Volumes {
+		if v.Name == credentialsVolumeName {
+			return nil, errors.Errorf("pod already has a volume named %s", credentialsVolumeName)
+		}
+	}
+
+	selected, err := selectContainers(p.Spec.Containers, annotations[InjectContainersAnnotation])
+
+	if err != nil {
+		return nil, err
+	}
+
+	initContainer := container{
+		Name:  initContainerName,
+		Image: s.config.InitContainerImage,
+		Env: []envVar{
+			{Name: "CREDENTIALS_PATH", Value: s.config.CredentialsPath},
+			fieldEnvVar("POD_NAMESPACE", "metadata.namespace"),
+			fieldEnvVar("POD_NAME", "metadata.name"),
+			fieldEnvVar("POD_UID", "metadata.uid"),
+			annotationEnvVar("DELIVERY", client.DeliveryAnnotation),
+			annotationEnvVar("VAULT_APPROLE", client.RoleAnnotation),
+			annotationEnvVar("VAULT_TOKEN_ROLE", client.TokenRoleAnnotation),
+			annotationEnvVar("VAULT_APPROLES", client.AppRolesAnnotation),
+			annotationEnvVar("VAULT_APPROLE_MOUNT_PATH", client.AppRoleMountPathAnnotation),
+		},
+		VolumeMounts: []volumeMount{
+			{Name: credentialsVolumeName, MountPath: s.config.CredentialsPath},
+		},
+	}
+
+	credentialsVolume := volume{
+		Name:     credentialsVolumeName,
+		EmptyDir: &emptyDir{},
+	}
+
+	patch := []patchOperation{
+		addToList("/spec/initContainers", len(p.Spec.InitContainers), initContainer),
+		addToList("/spec/volumes", len(p.Spec.Volumes), credentialsVolume),
+	}
+
+	for i, c := range p.Spec.Containers {
+
+		if !selected[c.Name] {
+			continue
+		}
+
+		mount := volumeMount{
+			Name:      credentialsVolumeName,
+			MountPath: s.config.CredentialsPath,
+			ReadOnly:  true,
+		}
+
+		patch = append(patch, addToList(fmt.Sprintf("/spec/containers/%d/volumeMounts", i), len(c.VolumeMounts), mount))
+	}
+
+	patch = append(patch, patchOperation{
+		Op:    "add",
+		Path:  "/metadata/annotations/" + escapeJSONPointer(client.InitContainerAnnotation),
+		Value: initContainerName,
+	})
+
+	return patch, nil
+}
+
+// fieldEnvVar returns an environment variable set to a field of the pod using the Downward API.
+func fieldEnvVar(name string, fieldPath string) envVar {
+	return envVar{
+		Name: name,
+		ValueFrom: &envVarSource{
+			FieldRef: &objectFieldSelector{
+				FieldPath: fieldPath,
+			},
+		},
+	}
+}
+
+// annotationEnvVar returns an environment variable set to the value of one of the pod's annotations using the Downward
+// API. It is empty if the pod does not have the annotation.
+func annotationEnvVar(name string, annotation string) envVar {
+	return fieldEnvVar(name, fmt.Sprintf("metadata.annotations['%s']", annotation))
+}
+
+// selectContainers returns the containers named in the comma-separated annotation, or all containers if it is empty.
+func selectContainers(containers []container, annotation string) (map[string]bool, error) {
+
+	selected := map[string]bool{}
+
+	if annotation == "" {
+
+		for _, c := range containers {
+			selected[c.Name] = true
+		}
+
+		return selected, nil
+	}
+
+	names := map[string]bool{}
+
+	for _, c := range containers {
+		names[c.Name] = true
+	}
+
+	for _, name := range strings.Split(annotation, ",") {
+
+		name = strings.TrimSpace(name)
+
+		if !names[name] {
+			return nil, errors.Errorf("container (%s) in the %s annotation does not exist", name, InjectContainersAnnotation)
+		}
+
+		selected[name] = true
+	}
+
+	return selected, nil
+}
+
+// addToList appends a value to a list, creating the list if it does not exist yet.
+func addToList(path string, length int, value interface{}) patchOperation {
+
+	if length == 0 {
+		return patchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: []interface{}{value},
+		}
+	}
+
+	return patchOperation{
+		Op:    "add",
+		Path:  path + "/-",
+		Value: value,
+	}
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testMutateServer(supplyRoleIds bool) *Server {
+	return &Server{
+		config: Config{
+			InitContainerImage: "boostport/kubernetes-vault-init:0.7.0",
+			CredentialsPath:    "/var/run/secrets/boostport.com",
+			SupplyRoleIds:      supplyRoleIds,
+		},
+	}
+}
+
+func TestMutate(t *testing.T) {
+
+	initContainer := `{"op":"add","path":"/spec/initContainers","value":[{"name":"kubernetes-vault-init",` +
+		`"image":"boostport/kubernetes-vault-init:0.7.0","env":[` +
+		`{"name":"CREDENTIALS_PATH","value":"/var/run/secrets/boostport.com"},` +
+		`{"name":"POD_NAMESPACE","valueFrom":{"fieldRef":{"fieldPath":"metadata.namespace"}}},` +
+		`{"name":"POD_NAME","valueFrom":{"fieldRef":{"fieldPath":"metadata.name"}}},` +
+		`{"name":"POD_UID","valueFrom":{"fieldRef":{"fieldPath":"metadata.uid"}}},` +
+		`{"name":"DELIVERY","valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['pod.boostport.com/vault-delivery']"}}},` +
+		`{"name":"VAULT_APPROLE","valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['pod.boostport.com/vault-approle']"}}},` +
+		`{"name":"VAULT_TOKEN_ROLE","valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['pod.boostport.com/vault-token-role']"}}},` +
+		`{"name":"VAULT_APPROLES","valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['pod.boostport.com/vault-approles']"}}},` +
+		`{"name":"VAULT_APPROLE_MOUNT_PATH","valueFrom":{"fieldRef":{"fieldPath":"metadata.annotations['pod.boostport.com/vault-approle-mount-path']"}}}],` +
+		`"volumeMounts":[{"name":"vault-credentials","mountPath":"/var/run/secrets/boostport.com"}]}]}`
+
+	volume := `{"op":"add","path":"/spec/volumes","value":[{"name":"vault-credentials","emptyDir":{}}]}`
+
+	annotation := `{"op":"add","path":"/metadata/annotations/pod.boostport.com~1vault-init-container","value":"kubernetes-vault-init"}`
+
+	mount := func(i int) string {
+		return `{"op":"add","path":"/spec/containers/` + strconv.Itoa(i) + `/volumeMounts","value":[` +
+			`{"name":"vault-credentials","mountPath":"/var/run/secrets/boostport.com","readOnly":true}]}`
+	}
+
+	tests := []struct {
+		name          string
+		pod           string
+		supplyRoleIds bool
+		patch         []string
+		err           string
+	}{
+		{
+			name:  "pod without a role",
+			pod:   `{"metadata":{"name":"app"},"spec":{"containers":[{"name":"app"}]}}`,
+			patch: nil,
+		},
+		{
+			name:          "pod with its own init container",
+			pod:           `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-approle":"sample-app","pod.boostport.com/vault-init-container":"vault-init"}},"spec":{"containers":[{"name":"app"}]}}`,
+			supplyRoleIds: true,
+			patch:         nil,
+		},
+		{
+			name:  "token role",
+			pod:   `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-token-role":"sample-app"}},"spec":{"containers":[{"name":"app"},{"name":"metrics"}]}}`,
+			patch: []string{initContainer, volume, mount(0), mount(1), annotation},
+		},
+		{
+			name:          "AppRole with supplied role_ids",
+			pod:           `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-approle":"sample-app","pod.boostport.com/vault-inject-containers":"metrics"}},"spec":{"containers":[{"name":"app"},{"name":"metrics"}]}}`,
+			supplyRoleIds: true,
+			patch:         []string{initContainer, volume, mount(1), annotation},
+		},
+		{
+			name: "AppRole without supplied role_ids",
+			pod:  `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-approle":"sample-app"}},"spec":{"containers":[{"name":"app"}]}}`,
+			err:  "vault.supplyRoleIds",
+		},
+		{
+			name: "pull delivery",
+			pod:  `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-token-role":"sample-app","pod.boostport.com/vault-delivery":"pull"}},"spec":{"containers":[{"name":"app"}]}}`,
+			err:  "pull delivery",
+		},
+		{
+			name: "unknown container",
+			pod:  `{"metadata":{"name":"app","annotations":{"pod.boostport.com/vault-token-role":"sample-app","pod.boostport.com/vault-inject-containers":"other"}},"spec":{"containers":[{"name":"app"}]}}`,
+			err:  "other",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			patch, err := testMutateServer(test.supplyRoleIds).mutate(json.RawMessage(test.pod))
+
+			if test.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got: %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(patch) != len(test.patch) {
+				t.Fatalf("expected %d patch operations, got %d", len(test.patch), len(patch))
+			}
+
+			for i, operation := range patch {
+
+				b, err := json.Marshal(operation)
+
+				if err != nil {
+					t.Fatalf("could not encode patch operation: %s", err)
+				}
+
+				if string(b) != test.patch[i] {
+					t.Errorf("patch operation %d:\nexpected %s\ngot      %s", i, test.patch[i], b)
+				}
+			}
+		})
+	}
+}
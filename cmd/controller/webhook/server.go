@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	Addr               string
+	InitContainerImage string
+	CredentialsPath    string
+	RoleNamespaces     []RoleNamespaces
+
+	// SupplyRoleIds is whether the controller supplies role_ids. The injected init container does not have
+	// VAULT_ROLE_ID set, so it is only injected for AppRoles if they are supplied.
+	SupplyRoleIds bool
+
+	// AllowedSecretPaths are the paths secrets may be read from, and RoleSecretPaths the patterns of the paths granted
+	// to each role. Pods requesting secrets whose paths are not both allowed and granted to their roles are rejected.
+	AllowedSecretPaths []string
+	RoleSecretPaths    map[string][]string
+
+	// Vault is used to check that AppRoles and token roles exist. If it is nil, they are not checked.
+	Vault *client.Vault
+
+	Logger *logrus.Logger
+}
+
+type Server struct {
+	config        Config
+	authorizer    roleAuthorizer
+	certificateCh <-chan tls.Certificate
+
+	sync.Mutex
+	certificate *tls.Certificate
+}
+
+func (s *Server) getCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.certificate, nil
+}
+
+func (s *Server) watchForNewCertificates() {
+	for cert := range s.certificateCh {
+		cert := cert
+
+		s.Lock()
+		s.certificate = &cert
+		s.Unlock()
+	}
+}
+
+// review decodes an AdmissionReview, passes the request to handle and writes back the response.
+func (s *Server) review(handle func(*admissionRequest) *admissionResponse) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var review admissionReview
+
+		if err := json.NewDecoder(req.Body).Decode(&review); err != nil || review.Request == nil {
+			s.config.Logger.Debugf("Could not decode admission review: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Could not decode admission review."))
+			return
+		}
+
+		response := handle(review.Request)
+		response.UID = review.Request.UID
+
+		review.Request = nil
+		review.Response = response
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			s.config.Logger.Errorf("Could not write admission review: %s", err)
+		}
+	}
+}
+
+func (s *Server) handleMutate(request *admissionRequest) *admissionResponse {
+
+	patch, err := s.mutate(request.Object)
+
+	if err != nil {
+		injectionFailures.Inc()
+		s.config.Logger.Errorf("Could not inject init container into pod in namespace (%s): %s", request.Namespace, err)
+
+		return &admissionResponse{
+			Allowed: false,
+			Result:  &status{Message: err.Error()},
+		}
+	}
+
+	if len(patch) == 0 {
+		return &admissionResponse{Allowed: true}
+	}
+
+	b, err := json.Marshal(patch)
+
+	if err != nil {
+		injectionFailures.Inc()
+
+		return &admissionResponse{
+			Allowed: false,
+			Result:  &status{Message: err.Error()},
+		}
+	}
+
+	injections.Inc()
+
+	return &admissionResponse{
+		Allowed:   true,
+		Patch:     b,
+		PatchType: "JSONPatch",
+	}
+}
+
+func (s *Server) handleValidate(request *admissionRequest) *admissionResponse {
+
+	var err error
+
+	if request.Operation == "UPDATE" {
+		err = validateUpdate(request.OldObject, request.Object)
+	} else {
+		err = s.validate(request.Namespace, request.Object)
+	}
+
+	validations.Inc()
+
+	if err != nil {
+		validationFailures.Inc()
+		s.config.Logger.Debugf("Rejected pod in namespace (%s): %s", request.Namespace, err)
+
+		return &admissionResponse{
+			Allowed: false,
+			Result:  &status{Message: err.Error()},
+		}
+	}
+
+	return &admissionResponse{Allowed: true}
+}
+
+func (s *Server) start() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", s.review(s.handleMutate))
+	mux.HandleFunc("/validate", s.review(s.handleValidate))
+
+	server := &http.Server{
+		Addr:    s.config.Addr,
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			GetCertificate: s.getCertificate,
+		},
+	}
+
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		s.config.Logger.Fatalf("Could not start webhook server: %s", err)
+	}
+}
+
+// StartServer serves the admission webhooks over https using the certificates received from certificateCh.
+func StartServer(certificateCh <-chan tls.Certificate, config Config) error {
+
+	authorizer, err := newRoleAuthorizer(config.RoleNamespaces)
+
+	if err != nil {
+		return err
+	}
+
+	server := &Server{
+		config:        config,
+		authorizer:    authorizer,
+		certificateCh: certificateCh,
+	}
+
+	go server.watchForNewCertificates()
+
+	go server.start()
+
+	return nil
+}
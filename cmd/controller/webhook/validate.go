@@ -0,0 +1,275 @@
+package webhook
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/pkg/errors"
+)
+
+// vaultAnnotationPrefix is the prefix of the annotations that configure which secrets a pod gets. They cannot be
+// changed after the pod is created, because the controller may be issuing secrets for them at the same time.
+const vaultAnnotationPrefix = "pod.boostport.com/vault-"
+
+// RoleNamespaces authorizes the namespaces matching any of the patterns to use a role. Patterns prefixed with ~ are
+// regexes.
+type RoleNamespaces struct {
+	Role       string   `mapstructure:"role"`
+	Namespaces []string `mapstructure:"namespaces"`
+}
+
+// roleAuthorizer checks whether a namespace is allowed to use a role. If no roles are configured, all namespaces may
+// use all roles.
+type roleAuthorizer map[string][]*regexp.Regexp
+
+func newRoleAuthorizer(roleNamespaces []RoleNamespaces) (roleAuthorizer, error) {
+
+	authorizer := roleAuthorizer{}
+
+	for _, r := range roleNamespaces {
+
+		for _, namespace := range r.Namespaces {
+
+			regex, err := client.NamespaceRegex(namespace)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid namespace (%s) for role (%s)", namespace, r.Role)
+			}
+
+			authorizer[r.Role] = append(authorizer[r.Role], regex)
+		}
+	}
+
+	return authorizer, nil
+}
+
+func (a roleAuthorizer) isAuthorized(role string, namespace string) bool {
+
+	if len(a) == 0 {
+		return true
+	}
+
+	for _, regex := range a[role] {
+		if regex.MatchString(namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate rejects pods with vault annotations that would never receive a secret: the annotations are invalid or
+// conflict, the init container does not exist, the namespace is not authorized to use the role, a secret path is not
+// granted to the pod's roles, or (optionally) the AppRole or token role does not exist in Vault.
+func (s *Server) validate(namespace string, object json.RawMessage) error {
+
+	var p pod
+
+	if err := json.Unmarshal(object, &p); err != nil {
+		return errors.Wrap(err, "could not decode pod")
+	}
+
+	annotations := p.Metadata.Annotations
+
+	role, hasRole := annotations[client.RoleAnnotation]
+	tokenRole, hasTokenRole := annotations[client.TokenRoleAnnotation]
+	_, hasAppRoles := annotations[client.AppRolesAnnotation]
+
+	if !(hasRole || hasTokenRole || hasAppRoles) {
+		return nil
+	}
+
+	if err := client.CheckRoleAnnotations(annotations); err != nil {
+		return err
+	}
+
+	appRoles, err := client.ParseAppRoleRequests(annotations[client.AppRolesAnnotation])
+
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s annotation", client.AppRolesAnnotation)
+	}
+
+	if hasRole {
+		appRoles = append(appRoles, client.AppRoleRequest{Role: role})
+	}
+
+	secrets, err := client.ParseSecretRequests(annotations[client.SecretsAnnotation])
+
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s annotation", client.SecretsAnnotation)
+	}
+
+	if _, err := client.ParseDelivery(annotations[client.DeliveryAnnotation]); err != nil {
+		return errors.Wrapf(err, "invalid %s annotation", client.DeliveryAnnotation)
+	}
+
+	initContainerName, hasInitContainer := annotations[client.InitContainerAnnotation]
+
+	if !hasInitContainer {
+		return errors.Errorf("the %s annotation is required", client.InitContainerAnnotation)
+	}
+
+	var initContainer *container
+
+	for i, c := range p.Spec.InitContainers {
+		if c.Name == initContainerName {
+			initContainer = &p.Spec.InitContainers[i]
+			break
+		}
+	}
+
+	if initContainer == nil {
+		return errors.Errorf("the init container (%s) named in the %s annotation does not exist", initContainerName, client.InitContainerAnnotation)
+	}
+
+	if hasAppRoles {
+		if feature := singleTokenFeature(*initContainer); feature != "" {
+			return errors.Errorf("the %s annotation cannot be used with an init container using %s, which needs a single auth token", client.AppRolesAnnotation, feature)
+		}
+	}
+
+	roles := []string{}
+
+	if hasTokenRole {
+
+		if !s.authorizer.isAuthorized(tokenRole, namespace) {
+			return errors.Errorf("namespace (%s) is not allowed to use the token role (%s)", namespace, tokenRole)
+		}
+
+		roles = append(roles, tokenRole)
+	}
+
+	for _, appRole := range appRoles {
+
+		if !s.authorizer.isAuthorized(appRole.Role, namespace) {
+			return errors.Errorf("namespace (%s) is not allowed to use the role (%s)", namespace, appRole.Role)
+		}
+
+		roles = append(roles, appRole.Role)
+	}
+
+	if err := s.checkSecretPaths(roles, secrets); err != nil {
+		return err
+	}
+
+	if s.config.Vault == nil {
+		return nil
+	}
+
+	if hasTokenRole {
+
+		err := s.config.Vault.CheckTokenRole(tokenRole)
+
+		if err == client.ErrRoleNotFound {
+			return errors.Errorf("the token role (%s) does not exist in Vault", tokenRole)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "could not check whether the token role (%s) exists in Vault", tokenRole)
+		}
+	}
+
+	for _, appRole := range appRoles {
+
+		_, err := s.config.Vault.GetRoleId(appRole.Role, annotations[client.AppRoleMountPathAnnotation])
+
+		if err == client.ErrRoleNotFound {
+			return errors.Errorf("the role (%s) does not exist in Vault", appRole.Role)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "could not check whether the role (%s) exists in Vault", appRole.Role)
+		}
+	}
+
+	return nil
+}
+
+// checkSecretPaths checks that the path of each secret is allowed and granted to one of the roles, as the controller
+// does before issuing the secrets.
+func (s *Server) checkSecretPaths(roles []string, secrets []client.SecretRequest) error {
+
+	patterns := []string{}
+
+	for _, role := range roles {
+		patterns = append(patterns, s.config.RoleSecretPaths[role]...)
+	}
+
+	for _, secret := range secrets {
+
+		if !client.MatchesSecretPath(s.config.AllowedSecretPaths, secret.Path) {
+			return errors.Errorf("the path (%s) of secret (%s) is not in the list of allowed secret paths", secret.Path, secret.Name)
+		}
+
+		if !client.MatchesSecretPath(patterns, secret.Path) {
+			return errors.Errorf("the path (%s) of secret (%s) is not granted to the pod's roles", secret.Path, secret.Name)
+		}
+	}
+
+	return nil
+}
+
+// singleTokenFeature returns the first feature enabled in the environment of the init container that needs a single
+// auth token, and so cannot be used with several AppRoles. It returns an empty string if there is none.
+func singleTokenFeature(c container) string {
+
+	for _, env := range c.Env {
+
+		switch {
+		case env.Name == "MODE" && env.Value == "sidecar":
+			return "the sidecar mode"
+		case env.Name == "PKI_BACKEND" && env.Value != "":
+			return "PKI_BACKEND"
+		case env.Name == "TEMPLATES_PATH" && env.Value != "":
+			return "TEMPLATES_PATH"
+		case env.Name == "CHILD_TOKENS" && env.Value != "":
+			return "CHILD_TOKENS"
+		}
+	}
+
+	return ""
+}
+
+// validateUpdate rejects updates of pods that add, change or remove vault annotations. Otherwise, a pod could be
+// admitted with a role its namespace is allowed to use and then switched to another role before its secret is issued.
+func validateUpdate(oldObject json.RawMessage, object json.RawMessage) error {
+
+	var oldPod, p pod
+
+	if err := json.Unmarshal(oldObject, &oldPod); err != nil {
+		return errors.Wrap(err, "could not decode old pod")
+	}
+
+	if err := json.Unmarshal(object, &p); err != nil {
+		return errors.Wrap(err, "could not decode pod")
+	}
+
+	old := oldPod.Metadata.Annotations
+	annotations := p.Metadata.Annotations
+
+	for name, value := range annotations {
+
+		if !strings.HasPrefix(name, vaultAnnotationPrefix) {
+			continue
+		}
+
+		if oldValue, ok := old[name]; !ok || oldValue != value {
+			return errors.Errorf("the %s annotation cannot be changed after the pod is created", name)
+		}
+	}
+
+	for name := range old {
+
+		if !strings.HasPrefix(name, vaultAnnotationPrefix) {
+			continue
+		}
+
+		if _, ok := annotations[name]; !ok {
+			return errors.Errorf("the %s annotation cannot be removed after the pod is created", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Boostport/kubernetes-vault/cmd/controller/client"
+	"github.com/sirupsen/logrus"
+)
+
+// testVaultServer responds like Vault does to the lookup of the controller's token, and for the token role sample-token
+// and the AppRole sample-app.
+func testVaultServer() *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var data map[string]interface{}
+
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			data = map[string]interface{}{"policies": []string{"kubernetes-vault"}, "renewable": true, "creation_ttl": 3600, "ttl": 3600}
+		case "/v1/auth/token/roles/sample-token":
+			data = map[string]interface{}{"allowed_policies": []string{"sample-token"}}
+		case "/v1/auth/approle/role/sample-app/role-id":
+			data = map[string]interface{}{"role_id": "e3d9f5b2-7a1c-4f0e-8b6d-2c4a9e1f3b57"}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func testPod(annotations map[string]string, env ...envVar) json.RawMessage {
+
+	var p pod
+
+	p.Metadata.Name = "app"
+	p.Metadata.Annotations = annotations
+	p.Spec.InitContainers = []container{{Name: "vault-init", Env: env}}
+	p.Spec.Containers = []container{{Name: "app"}}
+
+	b, _ := json.Marshal(p)
+
+	return b
+}
+
+func TestValidate(t *testing.T) {
+
+	vaultServer := testVaultServer()
+	defer vaultServer.Close()
+
+	vault, err := client.NewVault(vaultServer.URL, "token", true, "kubernetes-vault", "60s", "approle", nil, nil, false, nil, logrus.New())
+
+	if err != nil {
+		t.Fatalf("could not create vault client: %s", err)
+	}
+
+	defer vault.Shutdown()
+
+	authorizer, err := newRoleAuthorizer([]RoleNamespaces{
+		{Role: "sample-app", Namespaces: []string{"default"}},
+		{Role: "sample-token", Namespaces: []string{"default"}},
+		{Role: "other-app", Namespaces: []string{"default"}},
+	})
+
+	if err != nil {
+		t.Fatalf("could not create role authorizer: %s", err)
+	}
+
+	annotations := func(pairs ...string) map[string]string {
+
+		a := map[string]string{client.InitContainerAnnotation: "vault-init"}
+
+		for i := 0; i < len(pairs); i += 2 {
+			a[pairs[i]] = pairs[i+1]
+		}
+
+		return a
+	}
+
+	tests := []struct {
+		name        string
+		namespace   string
+		pod         json.RawMessage
+		verifyRoles bool
+		err         string
+	}{
+		{
+			name: "pod without vault annotations",
+			pod:  testPod(map[string]string{}),
+		},
+		{
+			name: "AppRole",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app")),
+		},
+		{
+			name: "token role",
+			pod:  testPod(annotations(client.TokenRoleAnnotation, "sample-token")),
+		},
+		{
+			name: "several AppRoles",
+			pod:  testPod(annotations(client.AppRolesAnnotation, "app=sample-app,other=other-app")),
+		},
+		{
+			name: "AppRole and token role",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.TokenRoleAnnotation, "sample-token")),
+			err:  "cannot be used together",
+		},
+		{
+			name: "several AppRoles and AppRole",
+			pod:  testPod(annotations(client.AppRolesAnnotation, "app=sample-app", client.RoleAnnotation, "sample-app")),
+			err:  "cannot be used together",
+		},
+		{
+			name: "invalid AppRoles annotation",
+			pod:  testPod(annotations(client.AppRolesAnnotation, "sample-app")),
+			err:  "invalid " + client.AppRolesAnnotation,
+		},
+		{
+			name: "invalid secrets annotation",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.SecretsAnnotation, `[{"name":"db"}]`)),
+			err:  "invalid " + client.SecretsAnnotation,
+		},
+		{
+			name: "invalid delivery",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.DeliveryAnnotation, "mail")),
+			err:  "invalid " + client.DeliveryAnnotation,
+		},
+		{
+			name: "missing init container annotation",
+			pod:  testPod(map[string]string{client.RoleAnnotation: "sample-app"}),
+			err:  "is required",
+		},
+		{
+			name: "unknown init container",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.InitContainerAnnotation, "other")),
+			err:  "does not exist",
+		},
+		{
+			name: "several AppRoles in sidecar mode",
+			pod:  testPod(annotations(client.AppRolesAnnotation, "app=sample-app"), envVar{Name: "MODE", Value: "sidecar"}),
+			err:  "the sidecar mode",
+		},
+		{
+			name:      "namespace not allowed to use the AppRole",
+			namespace: "other",
+			pod:       testPod(annotations(client.RoleAnnotation, "sample-app")),
+			err:       "not allowed to use the role",
+		},
+		{
+			name:      "namespace not allowed to use the token role",
+			namespace: "other",
+			pod:       testPod(annotations(client.TokenRoleAnnotation, "sample-token")),
+			err:       "not allowed to use the token role",
+		},
+		{
+			name: "granted secret path",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.SecretsAnnotation, `[{"name":"db","path":"secret/sample-app/db"}]`)),
+		},
+		{
+			name: "secret path that is not allowed",
+			pod:  testPod(annotations(client.RoleAnnotation, "sample-app", client.SecretsAnnotation, `[{"name":"db","path":"database/creds/sample-app"}]`)),
+			err:  "not in the list of allowed secret paths",
+		},
+		{
+			name: "secret path granted to another role",
+			pod:  testPod(annotations(client.TokenRoleAnnotation, "sample-token", client.SecretsAnnotation, `[{"name":"db","path":"secret/sample-app/db"}]`)),
+			err:  "not granted to the pod's roles",
+		},
+		{
+			name:        "existing AppRole",
+			pod:         testPod(annotations(client.RoleAnnotation, "sample-app")),
+			verifyRoles: true,
+		},
+		{
+			name:        "missing AppRole",
+			pod:         testPod(annotations(client.AppRolesAnnotation, "app=sample-app,other=other-app")),
+			verifyRoles: true,
+			err:         "the role (other-app) does not exist",
+		},
+		{
+			name:        "existing token role",
+			pod:         testPod(annotations(client.TokenRoleAnnotation, "sample-token")),
+			verifyRoles: true,
+		},
+		{
+			name:        "missing token role",
+			pod:         testPod(annotations(client.TokenRoleAnnotation, "other-app")),
+			verifyRoles: true,
+			err:         "the token role (other-app) does not exist",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			s := &Server{
+				config: Config{
+					AllowedSecretPaths: []string{"secret/*/*"},
+					RoleSecretPaths:    map[string][]string{"sample-app": {"secret/sample-app/*"}},
+				},
+				authorizer: authorizer,
+			}
+
+			if test.verifyRoles {
+				s.config.Vault = vault
+			}
+
+			namespace := test.namespace
+
+			if namespace == "" {
+				namespace = "default"
+			}
+
+			err := s.validate(namespace, test.pod)
+
+			if test.err == "" && err != nil {
+				t.Errorf("expected the pod to be admitted, got: %s", err)
+			}
+
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// childTokenConfig is a child token created from the auth token, with a subset of its policies, so that each container
+// in the pod can mount a token with only the policies it needs. It is set using CHILD_TOKENS,
+// CHILD_TOKEN_<NAME>_POLICIES and CHILD_TOKEN_<NAME>_TTL.
+type childTokenConfig struct {
+	name     string
+	policies []string
+	ttl      string
+}
+
+// childToken is a child token that was created, which the sidecar keeps renewed.
+type childToken struct {
+	name       string
+	token      authToken
+	expiration time.Time
+}
+
+func childTokensFromEnvironment() ([]childTokenConfig, error) {
+
+	children := []childTokenConfig{}
+
+	for _, name := range splitList(os.Getenv("CHILD_TOKENS")) {
+
+		if filepath.Base(name) != name {
+			return children, errors.Errorf("invalid child token name (%s). It is used in file names and cannot contain a path", name)
+		}
+
+		env := childTokenEnv(name)
+
+		policies := splitList(os.Getenv(env + "_POLICIES"))
+
+		if len(policies) == 0 {
+			return children, errors.Errorf("%s_POLICIES must be set for child token (%s)", env, name)
+		}
+
+		ttl := os.Getenv(env + "_TTL")
+
+		if ttl != "" {
+			if _, err := time.ParseDuration(ttl); err != nil {
+				return children, errors.Wrapf(err, "invalid %s_TTL (%s)", env, ttl)
+			}
+		}
+
+		children = append(children, childTokenConfig{
+			name:     name,
+			policies: policies,
+			ttl:      ttl,
+		})
+	}
+
+	return children, nil
+}
+
+// childTokenEnv returns the prefix of the environment variables configuring a child token, for example
+// CHILD_TOKEN_METRICS for metrics.
+func childTokenEnv(name string) string {
+	return "CHILD_TOKEN_" + common.EnvSuffix(name)
+}
+
+// createChildTokens creates the child tokens using the auth token. Vault only allows a child token to have policies
+// the auth token has, and revokes the child tokens when the auth token is revoked.
+func createChildTokens(token authToken, vaultCAs []byte, children []childTokenConfig, retry retrier) ([]*childToken, error) {
+
+	client, err := getAPIClient(token.VaultAddr, vaultCAs)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating vault client")
+	}
+
+	client.SetToken(token.ClientToken)
+
+	created := []*childToken{}
+
+	for _, child := range children {
+
+		var secret *api.Secret
+
+		err = retry.do("create child token", func() (err error) {
+			secret, err = client.Auth().Token().Create(&api.TokenCreateRequest{
+				Policies:    child.policies,
+				TTL:         child.ttl,
+				DisplayName: child.name,
+			})
+			return err
+		})
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create child token (%s)", child.name)
+		}
+
+		if secret == nil || secret.Auth == nil {
+			return nil, errors.Errorf("created child token (%s) is empty", child.name)
+		}
+
+		created = append(created, &childToken{
+			name: child.name,
+			token: authToken{
+				ClientToken:   secret.Auth.ClientToken,
+				Accessor:      secret.Auth.Accessor,
+				LeaseDuration: secret.Auth.LeaseDuration,
+				Renewable:     secret.Auth.Renewable,
+				VaultAddr:     token.VaultAddr,
+			},
+			expiration: time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second),
+		})
+	}
+
+	return created, nil
+}
+
+// credentials returns the child token as the credentials written for it.
+func (c *childToken) credentials() credentialsFile {
+	return credentialsFile{
+		response:  c.token,
+		token:     c.token,
+		fileName:  "vault-token",
+		tokenType: "child token (" + c.name + ")",
+	}
+}
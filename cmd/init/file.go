@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultFileMode = 0444
+	directoryMode   = 0755
+
+	// pkiDirName is the directory in the credentials path the certificate issued using PKI_BACKEND is written to.
+	pkiDirName = "tls"
+
+	// readyFileName is the marker file written after all other credentials, so that apps that cannot use the /ready
+	// endpoint can wait for it.
+	readyFileName = ".ready"
+
+	// temporaryFilePattern matches the temporary files created by writeFileAtomic and checkOwner.
+	temporaryFilePattern = ".*.tmp*"
+)
+
+// fileConfig is the owner and mode of the written credentials, set using FILE_UID, FILE_GID and FILE_MODE. A uid or
+// gid of -1 leaves it unchanged.
+type fileConfig struct {
+	uid  int
+	gid  int
+	mode os.FileMode
+}
+
+func fileConfigFromEnvironment() (fileConfig, error) {
+
+	config := fileConfig{
+		uid: -1,
+		gid: -1,
+	}
+
+	var err error
+
+	if config.uid, err = parseFileOwner("FILE_UID"); err != nil {
+		return config, err
+	}
+
+	if config.gid, err = parseFileOwner("FILE_GID"); err != nil {
+		return config, err
+	}
+
+	if config.mode, err = parseFileMode(os.Getenv("FILE_MODE"), defaultFileMode); err != nil {
+		return config, errors.Wrap(err, "invalid FILE_MODE")
+	}
+
+	return config, nil
+}
+
+func parseFileOwner(env string) (int, error) {
+
+	id := os.Getenv(env)
+
+	if id == "" {
+		return -1, nil
+	}
+
+	i, err := strconv.ParseUint(id, 10, 32)
+
+	if err != nil {
+		return -1, errors.Errorf("invalid %s (%s). It must be a numeric id", env, id)
+	}
+
+	return int(i), nil
+}
+
+// write atomically writes a credentials file with the configured owner and mode.
+func (f fileConfig) write(path string, data []byte) error {
+	return f.writeWithMode(path, data, f.mode)
+}
+
+// writeWithMode atomically writes a file with the configured owner and the given mode.
+func (f fileConfig) writeWithMode(path string, data []byte, mode os.FileMode) error {
+	return writeFileAtomic(path, data, mode, f.uid, f.gid)
+}
+
+// mkdir creates a directory for credentials with the configured owner.
+func (f fileConfig) mkdir(path string) error {
+
+	if err := os.MkdirAll(path, directoryMode); err != nil {
+		return err
+	}
+
+	if f.uid != -1 || f.gid != -1 {
+		return os.Chown(path, f.uid, f.gid)
+	}
+
+	return nil
+}
+
+// writeReady writes the .ready marker file. It must be written after all other credentials.
+func (f fileConfig) writeReady(credentialsPath string) error {
+
+	path := filepath.Join(credentialsPath, readyFileName)
+
+	if err := f.write(path, []byte{}); err != nil {
+		return errors.Wrapf(err, "could not write ready marker to path (%s)", path)
+	}
+
+	return nil
+}
+
+// removeReady removes a .ready marker left behind by a previous run of the container, so that apps do not start
+// before the credentials are written again.
+func removeReady(credentialsPath string) error {
+
+	path := filepath.Join(credentialsPath, readyFileName)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove ready marker at path (%s)", path)
+	}
+
+	return nil
+}
+
+// removeTemporaryFiles removes the temporary files of writeFileAtomic left behind if a previous run of the container
+// exited before renaming them, as they may contain credentials.
+func removeTemporaryFiles(credentialsPath string) error {
+
+	paths := []string{}
+
+	for _, dir := range []string{credentialsPath, filepath.Join(credentialsPath, pkiDirName)} {
+
+		matches, err := filepath.Glob(filepath.Join(dir, temporaryFilePattern))
+
+		if err != nil {
+			return errors.Wrap(err, "could not list temporary files")
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	for _, path := range paths {
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove temporary file at path (%s)", path)
+		}
+	}
+
+	return nil
+}
+
+// checkOwner checks that the owner of files written to credentialsPath can be changed to FILE_UID and FILE_GID, so that
+// a missing CHOWN capability is reported before a wrapped secret is used up.
+func (f fileConfig) checkOwner(credentialsPath string) error {
+
+	if f.uid == -1 && f.gid == -1 {
+		return nil
+	}
+
+	file, err := ioutil.TempFile(credentialsPath, ".owner-check.tmp")
+
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+
+	file.Close()
+
+	defer os.Remove(file.Name())
+
+	if err = os.Chown(file.Name(), f.uid, f.gid); err != nil {
+		return errors.Wrap(err, "could not change the owner of files to FILE_UID and FILE_GID, which requires the CHOWN capability")
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it to path, so that readers never
+// see a partially written file. The owner of the file is changed to uid and gid, unless they are -1.
+func writeFileAtomic(path string, data []byte, mode os.FileMode, uid int, gid int) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+
+	tmpPath := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Chown(tmpPath, uid, gid)
+	}
+
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "could not write file")
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listFiles returns the paths of the files in dir and its subdirectories, relative to dir.
+func listFiles(t *testing.T, dir string) []string {
+
+	files := []string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("could not list files: %s", err)
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		existing string
+		mode     os.FileMode
+		missing  bool
+		err      string
+	}{
+		{
+			name: "new file",
+			mode: 0444,
+		},
+		{
+			name:     "replace existing file",
+			existing: "old credentials",
+			mode:     0640,
+		},
+		{
+			name:    "missing directory",
+			mode:    0444,
+			missing: true,
+			err:     "could not create temporary file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+
+			if test.missing {
+				dir = filepath.Join(dir, "missing")
+			}
+
+			path := filepath.Join(dir, "vault-token")
+
+			if test.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(test.existing), 0600); err != nil {
+					t.Fatalf("could not write existing file: %s", err)
+				}
+			}
+
+			err := writeFileAtomic(path, []byte("credentials"), test.mode, -1, -1)
+
+			if test.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got: %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				t.Fatalf("could not read file: %s", err)
+			}
+
+			if string(b) != "credentials" {
+				t.Errorf("expected credentials, got %s", b)
+			}
+
+			info, err := os.Stat(path)
+
+			if err != nil {
+				t.Fatalf("could not stat file: %s", err)
+			}
+
+			if info.Mode().Perm() != test.mode {
+				t.Errorf("expected mode %s, got %s", test.mode, info.Mode().Perm())
+			}
+
+			if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"vault-token"}) {
+				t.Errorf("expected only the written file, got %v", files)
+			}
+		})
+	}
+}
+
+func TestRemoveTemporaryFiles(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		files     []string
+		remaining []string
+	}{
+		{
+			name:      "no temporary files",
+			files:     []string{".ready", "vault-token", "tls/tls.crt"},
+			remaining: []string{".ready", "tls/tls.crt", "vault-token"},
+		},
+		{
+			name:      "temporary files of writeFileAtomic and checkOwner",
+			files:     []string{".vault-token.tmp123", ".owner-check.tmp456", "tls/.tls.key.tmp789", "vault-token", "tls/tls.key"},
+			remaining: []string{"tls/tls.key", "vault-token"},
+		},
+		{
+			name:      "files that are not hidden",
+			files:     []string{"backup.tmp1", "vault-token.tmp"},
+			remaining: []string{"backup.tmp1", "vault-token.tmp"},
+		},
+		{
+			name:      "no tls directory",
+			files:     []string{".vault-token.tmp123"},
+			remaining: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+
+			for _, file := range test.files {
+
+				path := filepath.Join(dir, file)
+
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("could not create directory: %s", err)
+				}
+
+				if err := ioutil.WriteFile(path, []byte("credentials"), 0600); err != nil {
+					t.Fatalf("could not write file: %s", err)
+				}
+			}
+
+			if err := removeTemporaryFiles(dir); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if files := listFiles(t, dir); !reflect.DeepEqual(files, test.remaining) {
+				t.Errorf("expected %v, got %v", test.remaining, files)
+			}
+		})
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		files fileConfig
+		root  bool
+		err   string
+	}{
+		{
+			name:  "owner unchanged",
+			files: fileConfig{uid: -1, gid: -1},
+		},
+		{
+			name:  "current owner",
+			files: fileConfig{uid: os.Getuid(), gid: os.Getgid()},
+		},
+		{
+			name:  "other owner as root",
+			files: fileConfig{uid: 1000, gid: 1000},
+			root:  true,
+		},
+		{
+			name:  "other owner without the CHOWN capability",
+			files: fileConfig{uid: os.Getuid() + 1, gid: -1},
+			err:   "requires the CHOWN capability",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			isRoot := os.Getuid() == 0
+
+			if (test.root && !isRoot) || (test.err != "" && isRoot) {
+				t.Skip("depends on whether the test runs as root")
+			}
+
+			dir := t.TempDir()
+
+			err := test.files.checkOwner(dir)
+
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+
+			if files := listFiles(t, dir); len(files) != 0 {
+				t.Errorf("expected the temporary file to be removed, got %v", files)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Boostport/kubernetes-vault/common"
@@ -47,6 +48,66 @@ type wrappedSecretID struct {
 	TTL             int    `json:"ttl"`
 }
 
+type unwrappedSecret struct {
+	LeaseID       string                 `json:"leaseId"`
+	LeaseDuration int                    `json:"leaseDuration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	VaultAddr     string                 `json:"vaultAddr"`
+}
+
+type wrappedToken struct {
+	WrappedToken string `json:"wrappedToken"`
+	VaultAddr    string `json:"vaultAddr"`
+	TTL          int    `json:"ttl"`
+
+	// info is written by the agent format.
+	info wrapInfo
+}
+
+// newWrappedToken returns the wrapping token of a token delivered by the controller, with the wrap info Vault returned
+// when it was created.
+func newWrappedToken(w common.WrappedSecretId) wrappedToken {
+	return wrappedToken{
+		WrappedToken: w.SecretID,
+		VaultAddr:    w.VaultAddr,
+		TTL:          w.TTL,
+		info: wrapInfo{
+			Token:        w.SecretID,
+			TTL:          w.TTL,
+			CreationTime: w.CreationTime,
+			CreationPath: fmt.Sprintf("auth/token/create/%s", w.Role),
+		},
+	}
+}
+
+const (
+	pushDelivery   = "push"
+	pullDelivery   = "pull"
+	secretDelivery = "secret"
+)
+
+// receivedSecret is a wrapped secret received by the init container. If it was pushed, the result of unwrapping it is sent to
+// processed, so that the controller can push a new one if it cannot be unwrapped. Retries stop at deadline, if set.
+type receivedSecret struct {
+	wrappedSecretId common.WrappedSecretId
+	processed       chan<- error
+	deadline        time.Time
+}
+
+// podIdentity is the pod the init container runs in, as set using the Downward API.
+type podIdentity struct {
+	namespace string
+	name      string
+	uid       string
+}
+
+// complete returns whether the namespace, name and uid are all set, so that wrapped secrets intended for other pods are
+// always rejected.
+func (p podIdentity) complete() bool {
+	return p.namespace != "" && p.name != "" && p.uid != ""
+}
+
 var (
 	commit    string
 	tag       string
@@ -73,12 +134,6 @@ func main() {
 		logger.Level = logrus.ErrorLevel
 	}
 
-	roleID := os.Getenv("VAULT_ROLE_ID")
-
-	if roleID == "" {
-		logger.Fatal("The VAULT_ROLE_ID environment variable must be set.")
-	}
-
 	timeoutStr := os.Getenv("TIMEOUT")
 
 	var (
@@ -117,6 +172,20 @@ func main() {
 		logger.Fatal("UNWRAP_SECRET cannot be false if RETRIEVE_TOKEN is true")
 	}
 
+	mode := strings.ToLower(os.Getenv("MODE"))
+
+	if mode == "" {
+		mode = initMode
+	}
+
+	if mode != initMode && mode != sidecarMode {
+		logger.Fatalf(`Invalid MODE. Valid values are "%s" and "%s".`, initMode, sidecarMode)
+	}
+
+	if mode == sidecarMode && !retrieveToken {
+		logger.Fatal("RETRIEVE_TOKEN cannot be false if MODE is sidecar")
+	}
+
 	credentialsPath := os.Getenv("CREDENTIALS_PATH")
 
 	if credentialsPath == "" {
@@ -129,115 +198,549 @@ func main() {
 		logger.Fatalf("Error looking up external ip for container: %s", err)
 	}
 
-	certificate, err := generateCertificate(ip, timeout)
+	pki, err := pkiConfigFromEnvironment(ip)
+
+	if err != nil {
+		logger.Fatalf("Invalid PKI configuration: %s", err)
+	}
+
+	if pki != nil && !retrieveToken {
+		logger.Fatal("RETRIEVE_TOKEN cannot be false if a certificate is requested using PKI_BACKEND and PKI_ROLE")
+	}
+
+	templates, err := templatesFromEnvironment()
+
+	if err != nil {
+		logger.Fatalf("Invalid templates: %s", err)
+	}
+
+	if len(templates) > 0 && !retrieveToken {
+		logger.Fatal("RETRIEVE_TOKEN cannot be false if templates are set using TEMPLATES_PATH")
+	}
+
+	delivery := strings.ToLower(os.Getenv("DELIVERY"))
+
+	if delivery == "" {
+		delivery = pushDelivery
+	}
+
+	if delivery != pushDelivery && delivery != pullDelivery && delivery != secretDelivery {
+		logger.Fatalf(`Invalid DELIVERY. Valid values are "%s", "%s" and "%s".`, pushDelivery, pullDelivery, secretDelivery)
+	}
+
+	identity := podIdentity{
+		namespace: os.Getenv("POD_NAMESPACE"),
+		name:      os.Getenv("POD_NAME"),
+		uid:       os.Getenv("POD_UID"),
+	}
+
+	if delivery == secretDelivery && identity.name == "" {
+		logger.Fatal("POD_NAME must be set using the Downward API if DELIVERY is secret")
+	}
+
+	if !identity.complete() {
+		logger.Warn("POD_NAMESPACE, POD_NAME and POD_UID are not all set using the Downward API, so wrapped secrets intended for other pods may be accepted")
+	}
+
+	var pull *pullConfig
+
+	if delivery == pullDelivery {
+
+		pull, err = pullConfigFromEnvironment()
+
+		if err != nil {
+			logger.Fatalf("Invalid pull delivery configuration: %s", err)
+		}
+	}
+
+	expected, err := expectedRolesFromEnvironment()
+
+	if err != nil {
+		logger.Fatalf("Invalid expected roles: %s", err)
+	}
+
+	if !expected.configured() {
+		logger.Warn("VAULT_APPROLE, VAULT_TOKEN_ROLE and VAULT_APPROLES are not set, so wrapping tokens are not verified before they are unwrapped")
+	}
+
+	output, err := outputConfigFromEnvironment(unwrapSecret, retrieveToken, expected)
+
+	if err != nil {
+		logger.Fatalf("Invalid output configuration: %s", err)
+	}
+
+	wrapTTL, err := wrapTTLFromEnvironment()
+
+	if err != nil {
+		logger.Fatalf("Invalid wrapping configuration: %s", err)
+	}
+
+	if wrapTTL > 0 && !retrieveToken {
+		logger.Fatal("RETRIEVE_TOKEN cannot be false if WRAP_TOKEN_TTL is set")
+	}
+
+	if wrapTTL > 0 && mode == sidecarMode {
+		logger.Fatal("WRAP_TOKEN_TTL cannot be used if MODE is sidecar, because the sidecar writes the renewed auth token")
+	}
+
+	if wrapTTL > 0 && (output.has(tokenFormat) || output.has(dotenvFormat)) {
+		logger.Fatalf(`WRAP_TOKEN_TTL cannot be used with the "%s" and "%s" output formats, because they contain the auth token`, tokenFormat, dotenvFormat)
+	}
+
+	children, err := childTokensFromEnvironment()
+
+	if err != nil {
+		logger.Fatalf("Invalid child token configuration: %s", err)
+	}
+
+	if len(children) > 0 && !retrieveToken {
+		logger.Fatal("RETRIEVE_TOKEN cannot be false if CHILD_TOKENS is set")
+	}
+
+	// The sidecar, certificates, templates and child tokens need a single auth token, so the init container does not
+	// offer to accept several AppRoles and the controller does not issue secret_ids for them.
+	needsSingleToken := mode == sidecarMode || pki != nil || len(templates) > 0 || len(children) > 0
+
+	if needsSingleToken && hasAppRoleIDs() {
+		logger.Fatal("MODE=sidecar, PKI_BACKEND, TEMPLATES_PATH and CHILD_TOKENS cannot be used with multiple AppRoles (VAULT_ROLE_ID_<NAME>)")
+	}
+
+	handshake := common.NewHandshake()
+
+	if needsSingleToken {
+		handshake = handshake.Without(common.CapabilityAppRoles)
+	}
+
+	// The controller warns about init containers that cannot check the pod identity.
+	if !identity.complete() {
+		handshake = handshake.Without(common.CapabilityPodIdentity)
+	}
+
+	files, err := fileConfigFromEnvironment()
+
+	if err != nil {
+		logger.Fatalf("Invalid file configuration: %s", err)
+	}
+
+	if err = removeReady(credentialsPath); err != nil {
+		logger.Fatal(err)
+	}
+
+	if err = removeTemporaryFiles(credentialsPath); err != nil {
+		logger.Fatal(err)
+	}
+
+	if err = files.checkOwner(credentialsPath); err != nil {
+		logger.Fatalf("Invalid file configuration: %s", err)
+	}
+
+	retry, err := retrierFromEnvironment(logger)
+
+	if err != nil {
+		logger.Fatalf("Invalid retry configuration: %s", err)
+	}
+
+	serverCertificate, err := generateCertificate(ip, timeout)
 
 	if err != nil {
 		logger.Fatalf("Could not generate self-signed certificate: %s", err)
 	}
 
-	result := make(chan common.WrappedSecretId)
+	result := make(chan receivedSecret)
+
+	// Set to 1 once the credentials are written, so that a startupProbe can hold back the app containers when
+	// running as a native sidecar.
+	var ready int32
+
+	switch delivery {
+	case pullDelivery:
+		go startHTTPServer(serverCertificate, &ready, identity, handshake, expected, retry, logger, nil)
+		go pullWrappedSecret(pull, logger, result)
+
+	case secretDelivery:
+		go startHTTPServer(serverCertificate, &ready, identity, handshake, expected, retry, logger, nil)
+		go readWrappedSecret(identity.name, logger, result)
 
-	go startHTTPServer(certificate, logger, result)
+	default:
+		go startHTTPServer(serverCertificate, &ready, identity, handshake, expected, retry, logger, result)
+	}
 
+deliveries:
 	for {
 		select {
-		case wrappedSecretId := <-result:
+		case d := <-result:
+
+			wrappedSecretId := d.wrappedSecretId
+			retry := retry.until(d.deadline)
+
+			// issued are the auth tokens created using the wrapped secret. They are revoked if it is rejected, as they
+			// would otherwise stay valid after the controller pushes a new one.
+			var issued []authToken
+
+			// reject reports a permanent error to the controller, so that it pushes a new wrapped secret. Wrapped
+			// secrets that were not pushed cannot be rejected, so the init container exits.
+			reject := func(err error) {
+
+				for _, t := range issued {
+					if revokeErr := revokeToken(t, wrappedSecretId.VaultCAs, retry); revokeErr != nil {
+						logger.Errorf("Could not revoke auth token: %s", revokeErr)
+					}
+				}
+
+				if d.processed == nil {
+					logger.Fatal(err)
+				}
+
+				logger.Errorf("Rejected wrapped secret: %s", err)
+				d.processed <- err
+			}
 
 			if err := wrappedSecretId.Validate(); err != nil {
 				logger.Fatalf("could not validate wrapped secret_id: %s", err)
 			}
 
+			if err := wrappedSecretId.CheckPodIdentity(identity.namespace, identity.name, identity.uid); err != nil {
+				logger.Fatal(err)
+			}
+
+			// Pushed wrapped secrets are verified before they are accepted.
+			if delivery != pushDelivery {
+
+				if err := verifyWrappedSecretId(wrappedSecretId, expected, retry); err != nil {
+					logger.Fatalf("Refusing to unwrap the wrapped secret because it may have been intercepted: %s", err)
+				}
+			}
+
 			var (
 				response  interface{}
-				tokenPath string
+				token     authToken
+				fileName  string
+				tokenType string
 				err       error
 			)
 
-			if unwrapSecret {
-				client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+			caCertPath := ""
 
-				if err != nil {
-					logger.Fatalf("Error creating vault client: %s", err)
-				}
+			if len(wrappedSecretId.VaultCAs) > 0 {
+				caCertPath = filepath.Join(credentialsPath, "ca.crt")
+			}
 
-				sID, secretIDAccessor, err := unwrapSecretID(client, wrappedSecretId.SecretID)
+			if wrappedSecretId.GetDeliveryType() == common.TokenDelivery {
 
-				if err != nil {
-					logger.Fatalf("Could not unwrap secret: %s", err)
-				}
+				if unwrapSecret {
+					client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+
+					if err != nil {
+						logger.Fatalf("Error creating vault client: %s", err)
+					}
+
+					var authToken authToken
 
-				if retrieveToken {
-					authToken, err := login(client, roleID, sID)
+					err = retry.do("unwrap token", func() (err error) {
+						authToken, err = unwrapToken(client, wrappedSecretId.SecretID)
+						return err
+					})
 
 					if err != nil {
-						logger.Fatalf("Could not login to get auth token: %s", err)
+						reject(errors.Wrap(err, "could not unwrap token"))
+						continue deliveries
 					}
 
 					authToken.VaultAddr = wrappedSecretId.VaultAddr
+					issued = append(issued, authToken)
 
 					response = authToken
+					token = authToken
+					fileName = "vault-token"
+					tokenType = "auth token"
 
 				} else {
-					response = secretID{
-						RoleID:    roleID,
-						SecretID:  sID,
-						Accessor:  secretIDAccessor,
-						VaultAddr: wrappedSecretId.VaultAddr,
+					response = newWrappedToken(wrappedSecretId)
+					fileName = "vault-wrapped-token"
+					tokenType = "wrapped token"
+				}
+
+			} else if wrappedSecretId.GetDeliveryType() == common.AppRolesDelivery {
+
+				// Controllers check the handshake before issuing secret_ids, so this only happens with older ones.
+				if needsSingleToken {
+					logger.Fatal("MODE=sidecar, PKI_BACKEND, TEMPLATES_PATH and CHILD_TOKENS cannot be used with multiple AppRoles.")
+				}
+
+				for _, appRole := range wrappedSecretId.AppRoles {
+
+					appRoleID, err := resolveRoleID(common.AppRoleIDEnv(appRole.Name), appRole.RoleID)
+
+					if err != nil {
+						logger.Fatalf("Could not determine the role_id for AppRole (%s): %s", appRole.Name, err)
+					}
+
+					credentials, err := getAppRoleCredentials(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs, appRole.AppRoleMountPath, appRoleID, appRole.SecretID, appRole.TTL, unwrapSecret, retrieveToken, retry)
+
+					if err != nil {
+						reject(errors.Wrapf(err, "could not get credentials for AppRole (%s)", appRole.Name))
+						continue deliveries
+					}
+
+					if credentials.token.ClientToken != "" {
+						issued = append(issued, credentials.token)
+					}
+
+					if wrapTTL > 0 {
+
+						credentials, err = wrapCredentials(credentials, wrappedSecretId.VaultCAs, wrapTTL, retry)
+
+						if err != nil {
+							reject(errors.Wrapf(err, "could not wrap auth token for AppRole (%s)", appRole.Name))
+							continue deliveries
+						}
+					}
+
+					err = output.write(credentialsPath, "-"+appRole.Name, credentials, caCertPath, files)
+
+					if err != nil {
+						logger.Fatal(err)
 					}
 				}
 
 			} else {
-				response = wrappedSecretID{
-					RoleID:          roleID,
-					WrappedSecretID: wrappedSecretId.SecretID,
-					VaultAddr:       wrappedSecretId.VaultAddr,
-					TTL:             wrappedSecretId.TTL,
+
+				roleID, err := resolveRoleID("VAULT_ROLE_ID", wrappedSecretId.RoleID)
+
+				if err != nil {
+					logger.Fatalf("Could not determine the role_id: %s", err)
+				}
+
+				credentials, err := getAppRoleCredentials(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs, wrappedSecretId.GetAppRoleMountPath(), roleID, wrappedSecretId.SecretID, wrappedSecretId.TTL, unwrapSecret, retrieveToken, retry)
+
+				if err != nil {
+					reject(err)
+					continue deliveries
 				}
+
+				if credentials.token.ClientToken != "" {
+					issued = append(issued, credentials.token)
+				}
+
+				response = credentials.response
+				token = credentials.token
+				fileName = credentials.fileName
+				tokenType = credentials.tokenType
 			}
 
-			b, err := json.Marshal(response)
+			if response != nil {
 
-			if err != nil {
-				logger.Fatalf("Could not marshal auth token to JSON: %s", err)
+				credentials := credentialsFile{response: response, token: token, fileName: fileName, tokenType: tokenType}
+
+				// The auth token is still used to issue the certificate and render the templates below.
+				if wrapTTL > 0 {
+
+					credentials, err = wrapCredentials(credentials, wrappedSecretId.VaultCAs, wrapTTL, retry)
+
+					if err != nil {
+						reject(errors.Wrap(err, "could not wrap auth token"))
+						continue deliveries
+					}
+				}
+
+				err = output.write(credentialsPath, "", credentials, caCertPath, files)
+
+				if err != nil {
+					logger.Fatal(err)
+				}
 			}
 
-			if unwrapSecret {
-				if retrieveToken {
-					tokenPath = filepath.Join(credentialsPath, "vault-token")
-				} else {
-					tokenPath = filepath.Join(credentialsPath, "vault-secret-id")
+			var childTokens []*childToken
+
+			if len(children) > 0 {
+
+				// Revoking the auth token also revokes the child tokens already created.
+				childTokens, err = createChildTokens(token, wrappedSecretId.VaultCAs, children, retry)
+
+				if err != nil {
+					reject(err)
+					continue deliveries
+				}
+
+				for _, child := range childTokens {
+
+					credentials := child.credentials()
+
+					if wrapTTL > 0 {
+
+						credentials, err = wrapCredentials(credentials, wrappedSecretId.VaultCAs, wrapTTL, retry)
+
+						if err != nil {
+							reject(errors.Wrapf(err, "could not wrap child token (%s)", child.name))
+							continue deliveries
+						}
+					}
+
+					err = output.write(credentialsPath, "-"+child.name, credentials, caCertPath, files)
+
+					if err != nil {
+						logger.Fatal(err)
+					}
 				}
-			} else {
-				tokenPath = filepath.Join(credentialsPath, "vault-wrapped-secret-id")
 			}
 
-			err = ioutil.WriteFile(tokenPath, b, 0444)
+			for _, secret := range wrappedSecretId.Secrets {
+
+				var response interface{}
 
-			if err != nil {
-				tokenType := ""
 				if unwrapSecret {
-					if retrieveToken {
-						tokenType = "auth token"
-					} else {
-						tokenType = "secret_id"
+					client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+
+					if err != nil {
+						logger.Fatalf("Error creating vault client: %s", err)
+					}
+
+					var unwrapped unwrappedSecret
+
+					err = retry.do("unwrap secret", func() (err error) {
+						unwrapped, err = unwrapSecretData(client, secret.Token)
+						return err
+					})
+
+					if err != nil {
+						reject(errors.Wrapf(err, "could not unwrap secret (%s)", secret.Name))
+						continue deliveries
 					}
+
+					unwrapped.VaultAddr = wrappedSecretId.VaultAddr
+
+					response = unwrapped
+
 				} else {
-					tokenType = "wrapped secret_id"
+					response = wrappedToken{
+						WrappedToken: secret.Token,
+						VaultAddr:    wrappedSecretId.VaultAddr,
+						TTL:          secret.TTL,
+					}
+				}
+
+				b, err := json.Marshal(response)
+
+				if err != nil {
+					logger.Fatalf("Could not marshal secret (%s) to JSON: %s", secret.Name, err)
 				}
-				logger.Fatalf("Could not write %s to path (%s): %s", tokenType, tokenPath, err)
+
+				secretPath := filepath.Join(credentialsPath, secret.Name+".json")
+
+				err = files.write(secretPath, b)
+
+				if err != nil {
+					logger.Fatalf("Could not write secret (%s) to path (%s): %s", secret.Name, secretPath, err)
+				}
+			}
+
+			if d.processed != nil {
+				d.processed <- nil
+			}
+
+			var (
+				certificateExpiration time.Time
+				templatesExpiration   time.Time
+			)
+
+			if pki != nil {
+				client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+
+				if err != nil {
+					logger.Fatalf("Error creating vault client: %s", err)
+				}
+
+				client.SetToken(token.ClientToken)
+
+				cert, err := issueCertificate(client, pki)
+
+				if err != nil {
+					logger.Fatalf("Could not issue certificate: %s", err)
+				}
+
+				err = writeCertificate(credentialsPath, cert, files)
+
+				if err != nil {
+					logger.Fatalf("Could not write certificate: %s", err)
+				}
+
+				certificateExpiration = cert.Expiration
+			}
+
+			if len(templates) > 0 {
+				client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+
+				if err != nil {
+					logger.Fatalf("Error creating vault client: %s", err)
+				}
+
+				client.SetToken(token.ClientToken)
+
+				renderer := newTemplateRenderer(client, ip)
+
+				err = renderer.render(credentialsPath, templates, files)
+
+				if err != nil {
+					logger.Fatalf("Could not render templates: %s", err)
+				}
+
+				templatesExpiration = renderer.expiration
 			}
 
 			if len(wrappedSecretId.VaultCAs) > 0 {
 
 				caBundlePath := filepath.Join(credentialsPath, "ca.crt")
 
-				err = ioutil.WriteFile(caBundlePath, wrappedSecretId.VaultCAs, 0444)
+				err = files.write(caBundlePath, wrappedSecretId.VaultCAs)
 
 				if err != nil {
 					logger.Fatalf("Could not write CA bundle to path (%s): %s", caBundlePath, err)
 				}
 			}
 
+			if err = files.writeReady(credentialsPath); err != nil {
+				logger.Fatal(err)
+			}
+
+			atomic.StoreInt32(&ready, 1)
+
+			if mode == sidecarMode {
+				client, err := getAPIClient(wrappedSecretId.VaultAddr, wrappedSecretId.VaultCAs)
+
+				if err != nil {
+					logger.Fatalf("Error creating vault client: %s", err)
+				}
+
+				client.SetToken(token.ClientToken)
+
+				go func() {
+					for d := range result {
+						logger.Debug("Ignoring wrapped secret_id because the vault token was already created.")
+
+						if d.processed != nil {
+							d.processed <- nil
+						}
+					}
+				}()
+
+				logger.Debug("Successfully created the vault token. Keeping it renewed.")
+
+				s := &sidecar{
+					client:          client,
+					token:           token,
+					credentialsPath: credentialsPath,
+					output:          output,
+					files:           files,
+					childTokens:     childTokens,
+					ip:              ip,
+					pki:             pki,
+					templates:       templates,
+					logger:          logger,
+				}
+
+				s.run(certificateExpiration, templatesExpiration)
+			}
+
 			logger.Debug("Successfully created the vault token. Exiting.")
 			os.Exit(0)
 
@@ -251,7 +754,9 @@ func showBuildInfo() {
 	fmt.Printf("Kubernetes-Vault init container %s (%s) built on %s\n", tag, commit, buildDate)
 }
 
-func startHTTPServer(certificate tls.Certificate, logger *logrus.Logger, wrappedSecretId chan<- common.WrappedSecretId) {
+// startHTTPServer serves the /ready endpoint and, unless wrappedSecretId is nil because the wrapped secret is pulled
+// from the controller, accepts the wrapped secret pushed by the controller.
+func startHTTPServer(certificate tls.Certificate, ready *int32, identity podIdentity, handshake common.Handshake, expected expectedRoles, retry retrier, logger *logrus.Logger, deliveries chan<- receivedSecret) {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{certificate},
 	}
@@ -260,20 +765,48 @@ func startHTTPServer(certificate tls.Certificate, logger *logrus.Logger, wrapped
 
 	mux := http.NewServeMux()
 
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+
+		if atomic.LoadInt32(ready) != 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("The credentials have not been written yet."))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
 	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 
-		if req.URL.Path != "/" {
+		if req.URL.Path != "/" || deliveries == nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
+		if req.Method == "GET" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(handshake)
+			return
+		}
+
 		if req.Method == "POST" {
 
-			decoder := json.NewDecoder(req.Body)
+			body, err := ioutil.ReadAll(req.Body)
+
+			if err != nil {
+				logger.Debugf("Error reading wrapped secret: %s", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Could not read wrapped secret."))
+				return
+			}
 
-			var wrappedSecret common.WrappedSecretId
+			wrappedSecret, _, err := common.DecodeMessage(body)
 
-			err := decoder.Decode(&wrappedSecret)
+			if errors.Cause(err) == common.ErrUnsupportedVersion {
+				logger.Errorf("Rejected wrapped secret: %s", err)
+				writeErrorResponse(w, http.StatusBadRequest, common.UnsupportedVersion, err.Error())
+				return
+			}
 
 			if err != nil {
 				logger.Debugf("Error decoding wrapped secret: %s", err)
@@ -282,14 +815,43 @@ func startHTTPServer(certificate tls.Certificate, logger *logrus.Logger, wrapped
 				return
 			}
 
-			wrappedSecretId <- wrappedSecret
+			// Reject the wrapped secret without unwrapping it, so that the controller can push it to the right pod.
+			if err := wrappedSecret.CheckPodIdentity(identity.namespace, identity.name, identity.uid); err != nil {
+				logger.Errorf("Rejected wrapped secret: %s", err)
+				writeErrorResponse(w, http.StatusConflict, common.PodIdentityMismatch, err.Error())
+				return
+			}
+
+			// The controller waits for the response, so the retries must end before it gives up.
+			deadline := time.Now().Add(pushRetryBudget)
+
+			if err := verifyWrappedSecretId(wrappedSecret, expected, retry.until(deadline)); err != nil {
+				logger.Errorf("Refusing to unwrap the wrapped secret because it may have been intercepted: %s", err)
+				writeErrorResponse(w, http.StatusForbidden, common.WrappingTokenInvalid, err.Error())
+				return
+			}
+
+			// Wait until the wrapped secret is unwrapped, so that permanent errors are reported to the controller.
+			processed := make(chan error, 1)
+
+			deliveries <- receivedSecret{
+				wrappedSecretId: wrappedSecret,
+				processed:       processed,
+				deadline:        deadline,
+			}
+
+			if err := <-processed; err != nil {
+				writeErrorResponse(w, http.StatusUnprocessableEntity, common.UnwrapFailed, err.Error())
+				return
+			}
+
 			w.WriteHeader(http.StatusOK)
 			return
 
 		} else {
-			logger.Debugf("The / endpoint only support POSTs")
+			logger.Debugf("The / endpoint only support GETs and POSTs")
 			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte("The / endpoint only support POSTs"))
+			w.Write([]byte("The / endpoint only support GETs and POSTs"))
 		}
 
 	})
@@ -303,6 +865,142 @@ func startHTTPServer(certificate tls.Certificate, logger *logrus.Logger, wrapped
 	server.ListenAndServeTLS("", "")
 }
 
+// writeErrorResponse tells the controller why a wrapped secret was rejected.
+func writeErrorResponse(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(common.ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// credentialsFile is what the init container writes for a secret_id, depending on UNWRAP_SECRET and RETRIEVE_TOKEN.
+type credentialsFile struct {
+	response  interface{}
+	token     authToken
+	fileName  string
+	tokenType string
+}
+
+// revokeToken revokes an auth token using auth/token/revoke-self.
+func revokeToken(token authToken, vaultCAs []byte, retry retrier) error {
+
+	client, err := getAPIClient(token.VaultAddr, vaultCAs)
+
+	if err != nil {
+		return errors.Wrap(err, "error creating vault client")
+	}
+
+	client.SetToken(token.ClientToken)
+
+	return retry.do("revoke auth token", func() error {
+		return client.Auth().Token().RevokeSelf("")
+	})
+}
+
+func getAppRoleCredentials(vaultAddr string, vaultCAs []byte, mountPath string, roleID string, wrappedSecretId string, ttl int, unwrapSecret bool, retrieveToken bool, retry retrier) (credentialsFile, error) {
+
+	if !unwrapSecret {
+		return credentialsFile{
+			response: wrappedSecretID{
+				RoleID:          roleID,
+				WrappedSecretID: wrappedSecretId,
+				VaultAddr:       vaultAddr,
+				TTL:             ttl,
+			},
+			fileName:  "vault-wrapped-secret-id",
+			tokenType: "wrapped secret_id",
+		}, nil
+	}
+
+	client, err := getAPIClient(vaultAddr, vaultCAs)
+
+	if err != nil {
+		return credentialsFile{}, errors.Wrap(err, "error creating vault client")
+	}
+
+	var sID, secretIDAccessor string
+
+	err = retry.do("unwrap secret_id", func() (err error) {
+		sID, secretIDAccessor, err = unwrapSecretID(client, wrappedSecretId)
+		return err
+	})
+
+	if err != nil {
+		return credentialsFile{}, errors.Wrap(err, "could not unwrap secret")
+	}
+
+	if !retrieveToken {
+		return credentialsFile{
+			response: secretID{
+				RoleID:    roleID,
+				SecretID:  sID,
+				Accessor:  secretIDAccessor,
+				VaultAddr: vaultAddr,
+			},
+			fileName:  "vault-secret-id",
+			tokenType: "secret_id",
+		}, nil
+	}
+
+	var authToken authToken
+
+	err = retry.do("log in", func() (err error) {
+		authToken, err = login(client, mountPath, roleID, sID)
+		return err
+	})
+
+	if err != nil {
+		return credentialsFile{}, errors.Wrap(err, "could not login to get auth token")
+	}
+
+	authToken.VaultAddr = vaultAddr
+
+	return credentialsFile{
+		response:  authToken,
+		token:     authToken,
+		fileName:  "vault-token",
+		tokenType: "auth token",
+	}, nil
+}
+
+// resolveRoleID returns the role_id supplied by the controller, or the role_id in the environment variable if the
+// controller does not supply one. If both are set, they must match.
+func resolveRoleID(env string, supplied string) (string, error) {
+
+	roleID := os.Getenv(env)
+
+	if supplied == "" {
+
+		if roleID == "" {
+			return "", errors.Errorf("the %s environment variable must be set because the controller does not supply role_ids", env)
+		}
+
+		return roleID, nil
+	}
+
+	if roleID != "" && roleID != supplied {
+		return "", errors.Errorf("the role_id in the %s environment variable does not match the role_id supplied by the controller", env)
+	}
+
+	return supplied, nil
+}
+
+// hasAppRoleIDs returns whether the role_id of a named AppRole is set in the environment, which means that the pod
+// uses several AppRoles.
+func hasAppRoleIDs() bool {
+
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "VAULT_ROLE_ID_") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getAPIClient(vaultAddr string, rootCAs []byte) (*api.Client, error) {
 	var roots *x509.CertPool
 
@@ -349,9 +1047,51 @@ func unwrapSecretID(client *api.Client, secretID string) (string, string, error)
 	return secretID, secretIDAccessor, nil
 }
 
-func login(client *api.Client, roleID string, secretID string) (authToken, error) {
+func unwrapToken(client *api.Client, wrappedToken string) (authToken, error) {
+	client.SetToken(wrappedToken)
+
+	secret, err := client.Logical().Unwrap("")
+
+	if err != nil {
+		return authToken{}, errors.Wrap(err, "error unwrapping token")
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return authToken{}, errors.New("unwrapped token is empty. check whether the vault address is accessible from this pod and that the wrapped token exists.")
+	}
+
+	return authToken{
+		ClientToken:   secret.Auth.ClientToken,
+		Accessor:      secret.Auth.Accessor,
+		LeaseDuration: secret.Auth.LeaseDuration,
+		Renewable:     secret.Auth.Renewable,
+	}, nil
+}
+
+func unwrapSecretData(client *api.Client, wrappedToken string) (unwrappedSecret, error) {
+	client.SetToken(wrappedToken)
+
+	secret, err := client.Logical().Unwrap("")
+
+	if err != nil {
+		return unwrappedSecret{}, errors.Wrap(err, "error unwrapping secret")
+	}
+
+	if secret == nil {
+		return unwrappedSecret{}, errors.New("unwrapped secret is empty. check whether the vault address is accessible from this pod and that the wrapped secret exists.")
+	}
+
+	return unwrappedSecret{
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: secret.LeaseDuration,
+		Renewable:     secret.Renewable,
+		Data:          secret.Data,
+	}, nil
+}
+
+func login(client *api.Client, mountPath string, roleID string, secretID string) (authToken, error) {
 
-	token, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+	token, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", mountPath), map[string]interface{}{
 		"role_id":   roleID,
 		"secret_id": secretID,
 	})
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	jsonFormat   = "json"
+	tokenFormat  = "token"
+	dotenvFormat = "dotenv"
+	agentFormat  = "agent"
+)
+
+// defaultOutputFileNames are the file names of the output formats, except for json, whose file name depends on what
+// was delivered.
+var defaultOutputFileNames = map[string]string{
+	tokenFormat:  "token",
+	dotenvFormat: "vault.env",
+	agentFormat:  "vault-agent-token",
+}
+
+// outputConfig is the formats the credentials are written in and the names of the files, set using OUTPUT_FORMATS and
+// OUTPUT_FILE_<FORMAT>.
+type outputConfig struct {
+	formats   []string
+	fileNames map[string]string
+}
+
+// outputConfigFromEnvironment reads the output formats. Formats that need an auth token are rejected if none will be
+// written because of UNWRAP_SECRET, RETRIEVE_TOKEN and the expected roles, before a wrapped secret is used up.
+func outputConfigFromEnvironment(unwrapSecret bool, retrieveToken bool, expected expectedRoles) (outputConfig, error) {
+
+	config := outputConfig{
+		formats:   []string{jsonFormat},
+		fileNames: map[string]string{},
+	}
+
+	if formats := splitList(strings.ToLower(os.Getenv("OUTPUT_FORMATS"))); len(formats) > 0 {
+		config.formats = formats
+	}
+
+	for _, format := range config.formats {
+
+		if format != jsonFormat && format != tokenFormat && format != dotenvFormat && format != agentFormat {
+			return config, errors.Errorf(`invalid output format (%s). Valid formats are "%s", "%s", "%s" and "%s"`, format, jsonFormat, tokenFormat, dotenvFormat, agentFormat)
+		}
+
+		fileName := os.Getenv("OUTPUT_FILE_" + strings.ToUpper(format))
+
+		if fileName == "" {
+			fileName = defaultOutputFileNames[format]
+		}
+
+		if fileName != "" && filepath.Base(fileName) != fileName {
+			return config, errors.Errorf("OUTPUT_FILE_%s (%s) must be a file name, not a path", strings.ToUpper(format), fileName)
+		}
+
+		config.fileNames[format] = fileName
+	}
+
+	// AppRoles only result in an auth token if the secret_id is unwrapped and used to log in. Token roles result in
+	// one if the token is unwrapped, and the agent format can hold the wrapped token otherwise. If the roles are not
+	// set, either may be delivered, so only what both need is checked.
+	appRoleToken := unwrapSecret && retrieveToken
+	appRoles := expected.role != "" || len(expected.appRoles) > 0
+	tokenRole := expected.tokenRole != "" || !expected.configured()
+
+	for _, format := range config.formats {
+
+		switch format {
+		case tokenFormat, dotenvFormat:
+
+			if appRoles && !appRoleToken {
+				return config, errors.Errorf(`the "%s" output format needs UNWRAP_SECRET and RETRIEVE_TOKEN to be true`, format)
+			}
+
+			if tokenRole && !unwrapSecret {
+				return config, errors.Errorf(`the "%s" output format needs UNWRAP_SECRET to be true`, format)
+			}
+
+		case agentFormat:
+
+			if appRoles && !appRoleToken {
+				return config, errors.Errorf(`the "%s" output format needs UNWRAP_SECRET and RETRIEVE_TOKEN to be true when using AppRoles`, format)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// has returns whether credentials are written in a format.
+func (o outputConfig) has(format string) bool {
+
+	for _, f := range o.formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonFileName returns the name of the json file, which is defaultName unless OUTPUT_FILE_JSON is set.
+func (o outputConfig) jsonFileName(defaultName string) string {
+
+	if o.fileNames[jsonFormat] != "" {
+		return o.fileNames[jsonFormat]
+	}
+
+	return defaultName
+}
+
+// write writes the credentials in every output format. suffix is appended to the file names, so that the credentials
+// of several AppRoles are written to different files. caCertPath is the path of the CA bundle, if there is one.
+func (o outputConfig) write(credentialsPath string, suffix string, c credentialsFile, caCertPath string, files fileConfig) error {
+
+	for _, format := range o.formats {
+
+		var (
+			b   []byte
+			err error
+		)
+
+		fileName := o.fileNames[format]
+
+		switch format {
+		case jsonFormat:
+			fileName = o.jsonFileName(c.fileName)
+			b, err = json.Marshal(c.response)
+
+		case tokenFormat:
+			b, err = rawToken(c)
+
+		case dotenvFormat:
+			b, err = dotenv(c, caCertPath)
+
+		case agentFormat:
+			b, err = agentSink(c)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "could not write %s in the %s format", c.tokenType, format)
+		}
+
+		path := filepath.Join(credentialsPath, fileName+suffix)
+
+		if err = files.write(path, b); err != nil {
+			return errors.Wrapf(err, "could not write %s to path (%s)", c.tokenType, path)
+		}
+	}
+
+	return nil
+}
+
+// rawToken returns the client token by itself, which can be used with VAULT_TOKEN_FILE or read by the vault CLI.
+func rawToken(c credentialsFile) ([]byte, error) {
+
+	if c.token.ClientToken == "" {
+		return nil, errors.New("the token format requires an auth token")
+	}
+
+	return []byte(c.token.ClientToken), nil
+}
+
+// dotenv returns VAULT_TOKEN, VAULT_ADDR and VAULT_CACERT as a dotenv file.
+func dotenv(c credentialsFile, caCertPath string) ([]byte, error) {
+
+	if c.token.ClientToken == "" {
+		return nil, errors.New("the dotenv format requires an auth token")
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "VAULT_TOKEN=%s\n", c.token.ClientToken)
+	fmt.Fprintf(&buf, "VAULT_ADDR=%s\n", c.token.VaultAddr)
+
+	if caCertPath != "" {
+		fmt.Fprintf(&buf, "VAULT_CACERT=%s\n", caCertPath)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// agentSink returns the credentials in the format of a Vault Agent file sink: the client token by itself, or the
+// wrap info of the wrapping token as JSON if the token was not unwrapped or was wrapped using WRAP_TOKEN_TTL.
+func agentSink(c credentialsFile) ([]byte, error) {
+
+	if c.token.ClientToken != "" {
+		return []byte(c.token.ClientToken), nil
+	}
+
+	if wrapped, ok := c.response.(wrappedToken); ok {
+		return json.Marshal(wrapped.info)
+	}
+
+	return nil, errors.New("the agent format requires an auth token or a wrapped token")
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+)
+
+func TestOutputConfigFromEnvironment(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		unwrapSecret  bool
+		retrieveToken bool
+		expected      expectedRoles
+		config        outputConfig
+		err           string
+	}{
+		{
+			name:          "default",
+			unwrapSecret:  true,
+			retrieveToken: true,
+			expected:      expectedRoles{role: "sample-app"},
+			config: outputConfig{
+				formats:   []string{jsonFormat},
+				fileNames: map[string]string{jsonFormat: ""},
+			},
+		},
+		{
+			name:          "all formats with custom file names",
+			env:           map[string]string{"OUTPUT_FORMATS": "JSON, token,dotenv,agent", "OUTPUT_FILE_JSON": "credentials.json", "OUTPUT_FILE_DOTENV": ".env"},
+			unwrapSecret:  true,
+			retrieveToken: true,
+			expected:      expectedRoles{role: "sample-app"},
+			config: outputConfig{
+				formats:   []string{jsonFormat, tokenFormat, dotenvFormat, agentFormat},
+				fileNames: map[string]string{jsonFormat: "credentials.json", tokenFormat: "token", dotenvFormat: ".env", agentFormat: "vault-agent-token"},
+			},
+		},
+		{
+			name: "unknown format",
+			env:  map[string]string{"OUTPUT_FORMATS": "json,yaml"},
+			err:  "invalid output format (yaml)",
+		},
+		{
+			name: "file name with a path",
+			env:  map[string]string{"OUTPUT_FORMATS": "token", "OUTPUT_FILE_TOKEN": "../token"},
+			err:  "must be a file name",
+		},
+		{
+			name:          "token format without logging in using the AppRole",
+			env:           map[string]string{"OUTPUT_FORMATS": "token"},
+			unwrapSecret:  true,
+			retrieveToken: false,
+			expected:      expectedRoles{role: "sample-app"},
+			err:           "needs UNWRAP_SECRET and RETRIEVE_TOKEN",
+		},
+		{
+			name:         "dotenv format without unwrapping the token",
+			env:          map[string]string{"OUTPUT_FORMATS": "dotenv"},
+			unwrapSecret: false,
+			expected:     expectedRoles{tokenRole: "sample-app"},
+			err:          "needs UNWRAP_SECRET to be true",
+		},
+		{
+			name:         "token format without expected roles or unwrapping",
+			env:          map[string]string{"OUTPUT_FORMATS": "token"},
+			unwrapSecret: false,
+			err:          "needs UNWRAP_SECRET to be true",
+		},
+		{
+			name:         "agent format with a wrapped token",
+			env:          map[string]string{"OUTPUT_FORMATS": "agent"},
+			unwrapSecret: false,
+			expected:     expectedRoles{tokenRole: "sample-app"},
+			config: outputConfig{
+				formats:   []string{agentFormat},
+				fileNames: map[string]string{agentFormat: "vault-agent-token"},
+			},
+		},
+		{
+			name:         "agent format with a wrapped secret_id",
+			env:          map[string]string{"OUTPUT_FORMATS": "agent"},
+			unwrapSecret: false,
+			expected:     expectedRoles{appRoles: map[string]string{"app": "sample-app"}},
+			err:          "when using AppRoles",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			for _, env := range []string{"OUTPUT_FORMATS", "OUTPUT_FILE_JSON", "OUTPUT_FILE_TOKEN", "OUTPUT_FILE_DOTENV", "OUTPUT_FILE_AGENT"} {
+				t.Setenv(env, test.env[env])
+			}
+
+			config, err := outputConfigFromEnvironment(test.unwrapSecret, test.retrieveToken, test.expected)
+
+			if test.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got: %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(config, test.config) {
+				t.Errorf("expected %+v, got %+v", test.config, config)
+			}
+		})
+	}
+}
+
+func TestOutputConfigWrite(t *testing.T) {
+
+	token := credentialsFile{
+		response:  authToken{ClientToken: "s.token", Accessor: "accessor", LeaseDuration: 3600, Renewable: true, VaultAddr: "https://vault:8200"},
+		token:     authToken{ClientToken: "s.token", Accessor: "accessor", LeaseDuration: 3600, Renewable: true, VaultAddr: "https://vault:8200"},
+		fileName:  "vault-token",
+		tokenType: "auth token",
+	}
+
+	wrapped := credentialsFile{
+		response: wrappedToken{
+			WrappedToken: "s.wrapping",
+			VaultAddr:    "https://vault:8200",
+			TTL:          60,
+			info: wrapInfo{
+				Token:           "s.wrapping",
+				Accessor:        "wrapping-accessor",
+				TTL:             60,
+				CreationTime:    time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+				CreationPath:    "auth/token/create/sample-app",
+				WrappedAccessor: "token-accessor",
+			},
+		},
+		fileName:  "vault-token",
+		tokenType: "wrapped token",
+	}
+
+	tests := []struct {
+		name        string
+		format      string
+		credentials credentialsFile
+		caCertPath  string
+		fileName    string
+		content     string
+		err         string
+	}{
+		{
+			name:        "json",
+			format:      jsonFormat,
+			credentials: token,
+			fileName:    "vault-token",
+			content:     `{"clientToken":"s.token","accessor":"accessor","leaseDuration":3600,"renewable":true,"vaultAddr":"https://vault:8200"}`,
+		},
+		{
+			name:        "token",
+			format:      tokenFormat,
+			credentials: token,
+			fileName:    "token",
+			content:     "s.token",
+		},
+		{
+			name:        "token without an auth token",
+			format:      tokenFormat,
+			credentials: wrapped,
+			err:         "requires an auth token",
+		},
+		{
+			name:        "dotenv",
+			format:      dotenvFormat,
+			credentials: token,
+			fileName:    "vault.env",
+			content:     "VAULT_TOKEN=s.token\nVAULT_ADDR=https://vault:8200\n",
+		},
+		{
+			name:        "dotenv with a CA bundle",
+			format:      dotenvFormat,
+			credentials: token,
+			caCertPath:  "/var/run/secrets/boostport.com/ca.crt",
+			fileName:    "vault.env",
+			content:     "VAULT_TOKEN=s.token\nVAULT_ADDR=https://vault:8200\nVAULT_CACERT=/var/run/secrets/boostport.com/ca.crt\n",
+		},
+		{
+			name:        "agent",
+			format:      agentFormat,
+			credentials: token,
+			fileName:    "vault-agent-token",
+			content:     "s.token",
+		},
+		{
+			name:        "agent with a wrapped token",
+			format:      agentFormat,
+			credentials: wrapped,
+			fileName:    "vault-agent-token",
+			content:     `{"token":"s.wrapping","accessor":"wrapping-accessor","ttl":60,"creation_time":"2017-03-01T12:00:00Z","creation_path":"auth/token/create/sample-app","wrapped_accessor":"token-accessor"}`,
+		},
+		{
+			name:        "agent without a token",
+			format:      agentFormat,
+			credentials: credentialsFile{response: secretID{SecretID: "secret-id"}, tokenType: "secret_id"},
+			err:         "requires an auth token or a wrapped token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+
+			config := outputConfig{
+				formats:   []string{test.format},
+				fileNames: map[string]string{test.format: defaultOutputFileNames[test.format]},
+			}
+
+			err := config.write(dir, "", test.credentials, test.caCertPath, fileConfig{uid: -1, gid: -1, mode: 0644})
+
+			if test.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got: %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, test.fileName))
+
+			if err != nil {
+				t.Fatalf("could not read output: %s", err)
+			}
+
+			if string(b) != test.content {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.content, b)
+			}
+		})
+	}
+}
+
+func TestNewWrappedTokenAgentSink(t *testing.T) {
+
+	w := testWrappedSecretId("https://vault:8200")
+	w.DeliveryType = common.TokenDelivery
+
+	b, err := agentSink(credentialsFile{response: newWrappedToken(w)})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `{"token":"b7f2a6c5-0f6e-4d7c-9a51-3b0e1c5d2a11","accessor":"","ttl":60,"creation_time":"2017-03-01T12:00:00Z",` +
+		`"creation_path":"auth/token/create/sample-app","wrapped_accessor":""}`
+
+	if string(b) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type pkiConfig struct {
+	Backend    string
+	Role       string
+	CommonName string
+	AltNames   []string
+	IPSANs     []string
+	TTL        string
+}
+
+type certificate struct {
+	Certificate []byte
+	PrivateKey  []byte
+	IssuingCA   []byte
+	Expiration  time.Time
+}
+
+// pkiConfigFromEnvironment returns the PKI configuration set using environment variables. If PKI_BACKEND and PKI_ROLE
+// are not set, a certificate is not requested and nil is returned.
+func pkiConfigFromEnvironment(ip net.IP) (*pkiConfig, error) {
+
+	backend := strings.Trim(os.Getenv("PKI_BACKEND"), "/")
+	role := os.Getenv("PKI_ROLE")
+
+	if backend == "" && role == "" {
+		return nil, nil
+	}
+
+	if backend == "" || role == "" {
+		return nil, errors.New("PKI_BACKEND and PKI_ROLE must both be set to request a certificate")
+	}
+
+	commonName := os.Getenv("PKI_COMMON_NAME")
+
+	if commonName == "" {
+		return nil, errors.New("PKI_COMMON_NAME must be set to request a certificate")
+	}
+
+	config := &pkiConfig{
+		Backend:    backend,
+		Role:       role,
+		CommonName: commonName,
+		AltNames:   splitList(os.Getenv("PKI_ALT_NAMES")),
+		IPSANs:     splitList(os.Getenv("PKI_IP_SANS")),
+		TTL:        os.Getenv("PKI_TTL"),
+	}
+
+	if len(config.IPSANs) == 0 {
+		config.IPSANs = []string{ip.String()}
+	}
+
+	for _, ipSAN := range config.IPSANs {
+		if net.ParseIP(ipSAN) == nil {
+			return nil, errors.Errorf("PKI_IP_SANS contains an invalid ip address (%s)", ipSAN)
+		}
+	}
+
+	if config.TTL != "" {
+		if _, err := time.ParseDuration(config.TTL); err != nil {
+			return nil, errors.Wrapf(err, "invalid PKI_TTL (%s)", config.TTL)
+		}
+	}
+
+	return config, nil
+}
+
+func issueCertificate(client *api.Client, config *pkiConfig) (certificate, error) {
+
+	data := map[string]interface{}{
+		"common_name": config.CommonName,
+		"ip_sans":     strings.Join(config.IPSANs, ","),
+	}
+
+	if len(config.AltNames) > 0 {
+		data["alt_names"] = strings.Join(config.AltNames, ",")
+	}
+
+	if config.TTL != "" {
+		data["ttl"] = config.TTL
+	}
+
+	secret, err := client.Logical().Write(fmt.Sprintf("%s/issue/%s", config.Backend, config.Role), data)
+
+	if err != nil {
+		return certificate{}, errors.Wrap(err, "error issuing certificate")
+	}
+
+	if secret == nil {
+		return certificate{}, errors.New("issued certificate is empty")
+	}
+
+	cert, ok := secret.Data["certificate"].(string)
+
+	if !ok {
+		return certificate{}, errors.New("issued certificate is missing the certificate")
+	}
+
+	key, ok := secret.Data["private_key"].(string)
+
+	if !ok {
+		return certificate{}, errors.New("issued certificate is missing the private key")
+	}
+
+	issuingCA, _ := secret.Data["issuing_ca"].(string)
+
+	certs := cert
+
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+
+		for _, c := range chain {
+			if chainCert, ok := c.(string); ok && chainCert != issuingCA {
+				certs += "\n" + chainCert
+			}
+		}
+	}
+
+	block, _ := pem.Decode([]byte(cert))
+
+	if block == nil {
+		return certificate{}, errors.New("could not decode issued certificate")
+	}
+
+	parsed, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		return certificate{}, errors.Wrap(err, "could not parse issued certificate")
+	}
+
+	return certificate{
+		Certificate: []byte(certs),
+		PrivateKey:  []byte(key),
+		IssuingCA:   []byte(issuingCA),
+		Expiration:  parsed.NotAfter,
+	}, nil
+}
+
+// writeCertificate writes tls.crt, tls.key and the issuing CA as ca.crt to the tls directory in credentialsPath. They
+// are kept out of credentialsPath itself, so that apps using its ca.crt to verify Vault do not trust every certificate
+// the PKI backend issues.
+func writeCertificate(credentialsPath string, cert certificate, files fileConfig) error {
+
+	dir := filepath.Join(credentialsPath, pkiDirName)
+
+	if err := files.mkdir(dir); err != nil {
+		return errors.Wrapf(err, "could not create directory (%s)", dir)
+	}
+
+	certPath := filepath.Join(dir, "tls.crt")
+
+	if err := files.write(certPath, cert.Certificate); err != nil {
+		return errors.Wrapf(err, "could not write certificate to path (%s)", certPath)
+	}
+
+	keyPath := filepath.Join(dir, "tls.key")
+
+	if err := files.write(keyPath, cert.PrivateKey); err != nil {
+		return errors.Wrapf(err, "could not write private key to path (%s)", keyPath)
+	}
+
+	if len(bytes.TrimSpace(cert.IssuingCA)) > 0 {
+
+		caPath := filepath.Join(dir, "ca.crt")
+
+		if err := files.write(caPath, cert.IssuingCA); err != nil {
+			return errors.Wrapf(err, "could not write issuing CA to path (%s)", caPath)
+		}
+	}
+
+	return nil
+}
+
+func splitList(list string) []string {
+
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
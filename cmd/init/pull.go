@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	pullRequestTimeout             = 30 * time.Second
+)
+
+type pullConfig struct {
+	ControllerAddr string
+	TokenPath      string
+	RootCAs        *x509.CertPool
+}
+
+// pullConfigFromEnvironment returns the configuration for requesting the wrapped secret from the controller.
+func pullConfigFromEnvironment() (*pullConfig, error) {
+
+	controllerAddr := strings.TrimRight(os.Getenv("CONTROLLER_ADDR"), "/")
+
+	if !strings.HasPrefix(controllerAddr, "https://") {
+		return nil, errors.New("CONTROLLER_ADDR must be set to the https address of the controller when DELIVERY is pull")
+	}
+
+	config := &pullConfig{
+		ControllerAddr: controllerAddr,
+		TokenPath:      os.Getenv("SERVICE_ACCOUNT_TOKEN_PATH"),
+	}
+
+	if config.TokenPath == "" {
+		config.TokenPath = defaultServiceAccountTokenPath
+	}
+
+	if caCert := os.Getenv("CONTROLLER_CA_CERT"); caCert != "" {
+
+		p, err := ioutil.ReadFile(caCert)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read CONTROLLER_CA_CERT (%s)", caCert)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+
+		if !config.RootCAs.AppendCertsFromPEM(p) {
+			return nil, errors.Errorf("CONTROLLER_CA_CERT (%s) does not contain any certificates", caCert)
+		}
+	}
+
+	return config, nil
+}
+
+// pullWrappedSecret requests the wrapped secret from the controller, authenticating with the pod's service account
+// token, and retries until it succeeds.
+func pullWrappedSecret(config *pullConfig, logger *logrus.Logger, result chan<- receivedSecret) {
+
+	transport := cleanhttp.DefaultTransport()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs: config.RootCAs,
+	}
+
+	client := &http.Client{Transport: transport}
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = 0
+
+	var wrappedSecret common.WrappedSecretId
+
+	op := func() error {
+
+		// Projected service account tokens are rotated, so read the token every time.
+		token, err := ioutil.ReadFile(config.TokenPath)
+
+		if err != nil {
+			return errors.Wrapf(err, "could not read service account token (%s)", config.TokenPath)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pullRequestTimeout)
+		defer cancel()
+
+		req, err := http.NewRequest("POST", config.ControllerAddr+common.PullPath, nil)
+
+		if err != nil {
+			return errors.Wrap(err, "could not create request")
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+		response, err := client.Do(req.WithContext(ctx))
+
+		if err != nil {
+			return errors.Wrap(err, "could not request wrapped secret from controller")
+		}
+
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			message, _ := ioutil.ReadAll(response.Body)
+			return errors.Errorf("controller responded with %s: %s", response.Status, strings.TrimSpace(string(message)))
+		}
+
+		wrappedSecret = common.WrappedSecretId{}
+
+		if err := json.NewDecoder(response.Body).Decode(&wrappedSecret); err != nil {
+			return errors.Wrap(err, "could not decode wrapped secret")
+		}
+
+		return nil
+	}
+
+	notify := func(err error, wait time.Duration) {
+		logger.Debugf("Could not pull wrapped secret, retrying in %s: %s", wait, err)
+	}
+
+	backoff.RetryNotify(op, exp, notify)
+
+	result <- receivedSecret{wrappedSecretId: wrappedSecret}
+}
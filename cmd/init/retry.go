@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultVaultRetries       = 5
+	defaultVaultRetryInterval = 500 * time.Millisecond
+
+	// pushRetryBudget bounds the retries made while handling a pushed wrapped secret, so that the init container
+	// responds before the controller's 30 second push timeout and the controller does not push the same one again.
+	pushRetryBudget = 20 * time.Second
+)
+
+// vaultStatusCodeRegex extracts the status code from the errors returned by the Vault client, which are not typed.
+var vaultStatusCodeRegex = regexp.MustCompile(`Code: (\d{3})\.`)
+
+// retrier retries requests to Vault that time out, are refused or fail with a 5xx response.
+type retrier struct {
+	maxRetries      uint64
+	initialInterval time.Duration
+	deadline        time.Time
+	logger          *logrus.Logger
+}
+
+// retrierFromEnvironment configures the retries using VAULT_RETRIES and VAULT_RETRY_INTERVAL.
+func retrierFromEnvironment(logger *logrus.Logger) (retrier, error) {
+
+	r := retrier{
+		maxRetries:      defaultVaultRetries,
+		initialInterval: defaultVaultRetryInterval,
+		logger:          logger,
+	}
+
+	if retries := os.Getenv("VAULT_RETRIES"); retries != "" {
+
+		maxRetries, err := strconv.ParseUint(retries, 10, 64)
+
+		if err != nil {
+			return r, errors.Wrapf(err, "invalid VAULT_RETRIES (%s)", retries)
+		}
+
+		r.maxRetries = maxRetries
+	}
+
+	if interval := os.Getenv("VAULT_RETRY_INTERVAL"); interval != "" {
+
+		initialInterval, err := time.ParseDuration(interval)
+
+		if err != nil {
+			return r, errors.Wrapf(err, "invalid VAULT_RETRY_INTERVAL (%s)", interval)
+		}
+
+		r.initialInterval = initialInterval
+	}
+
+	return r, nil
+}
+
+// until returns a copy of the retrier that does not start a retry that would begin after deadline. A zero deadline
+// does not limit the retries.
+func (r retrier) until(deadline time.Time) retrier {
+	r.deadline = deadline
+	return r
+}
+
+// deadlineBackOff stops a backoff once the next retry would begin after the deadline.
+type deadlineBackOff struct {
+	backoff.BackOff
+	deadline time.Time
+}
+
+func (b deadlineBackOff) NextBackOff() time.Duration {
+
+	next := b.BackOff.NextBackOff()
+
+	if next != backoff.Stop && time.Now().Add(next).After(b.deadline) {
+		return backoff.Stop
+	}
+
+	return next
+}
+
+// do calls op until it succeeds, fails with an error that is not transient or the retries are used up.
+func (r retrier) do(description string, op func() error) error {
+
+	if r.maxRetries == 0 {
+		return op()
+	}
+
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = r.initialInterval
+	exp.MaxElapsedTime = 0
+
+	operation := func() error {
+
+		err := op()
+
+		if err != nil && !isTransient(err) {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}
+
+	notify := func(err error, wait time.Duration) {
+		r.logger.Debugf("Could not %s, retrying in %s: %s", description, wait, err)
+	}
+
+	var b backoff.BackOff = backoff.WithMaxTries(exp, r.maxRetries)
+
+	if !r.deadline.IsZero() {
+		b = deadlineBackOff{BackOff: b, deadline: r.deadline}
+	}
+
+	return backoff.RetryNotify(operation, b, notify)
+}
+
+// isTransient returns whether an error is a network timeout, a refused connection or a 5xx response from Vault. Other
+// network errors, such as TLS and certificate verification failures, are not retried.
+func isTransient(err error) bool {
+
+	cause := errors.Cause(err)
+
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	if isConnectionRefused(cause) {
+		return true
+	}
+
+	matches := vaultStatusCodeRegex.FindStringSubmatch(cause.Error())
+
+	if len(matches) != 2 {
+		return false
+	}
+
+	code, _ := strconv.Atoi(matches[1])
+
+	return code >= 500
+}
+
+// isConnectionRefused returns whether the error, or an error it wraps, is ECONNREFUSED.
+func isConnectionRefused(err error) bool {
+
+	for err != nil {
+
+		if err == syscall.ECONNREFUSED {
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+
+		if !ok {
+			return false
+		}
+
+		err = wrapper.Unwrap()
+	}
+
+	return false
+}
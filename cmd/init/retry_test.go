@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+)
+
+// timeoutError is a net.Error like the one returned when a request to Vault times out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+
+	urlError := func(err error) error {
+		return &url.Error{Op: "Put", URL: "https://vault:8200/v1/sys/wrapping/unwrap", Err: err}
+	}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{
+			name:      "timeout",
+			err:       urlError(timeoutError{}),
+			transient: true,
+		},
+		{
+			name:      "connection refused",
+			err:       urlError(refused),
+			transient: true,
+		},
+		{
+			name:      "wrapped connection refused",
+			err:       errors.Wrap(urlError(refused), "could not unwrap"),
+			transient: true,
+		},
+		{
+			name:      "connection reset",
+			err:       urlError(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}),
+			transient: false,
+		},
+		{
+			name:      "unknown certificate authority",
+			err:       urlError(x509.UnknownAuthorityError{}),
+			transient: false,
+		},
+		{
+			name:      "invalid certificate host",
+			err:       urlError(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "vault"}),
+			transient: false,
+		},
+		{
+			name:      "5xx response",
+			err:       errors.Wrap(errors.New("Error making API request.\n\nURL: PUT https://vault:8200/v1/sys/wrapping/unwrap\nCode: 503. Errors:\n\n* Vault is sealed"), "could not unwrap"),
+			transient: true,
+		},
+		{
+			name:      "4xx response",
+			err:       errors.New("Error making API request.\n\nURL: PUT https://vault:8200/v1/sys/wrapping/unwrap\nCode: 400. Errors:\n\n* wrapping token is not valid or does not exist"),
+			transient: false,
+		},
+		{
+			name:      "other error",
+			err:       errors.New("wrapping token was created for a different path"),
+			transient: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if transient := isTransient(test.err); transient != test.transient {
+				t.Errorf("expected %t, got %t", test.transient, transient)
+			}
+		})
+	}
+}
+
+func TestDeadlineBackOff(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		backOff  backoff.BackOff
+		deadline time.Time
+		next     time.Duration
+	}{
+		{
+			name:     "retry before the deadline",
+			backOff:  backoff.NewConstantBackOff(time.Second),
+			deadline: time.Now().Add(time.Minute),
+			next:     time.Second,
+		},
+		{
+			name:     "retry after the deadline",
+			backOff:  backoff.NewConstantBackOff(time.Minute),
+			deadline: time.Now().Add(time.Second),
+			next:     backoff.Stop,
+		},
+		{
+			name:     "deadline passed",
+			backOff:  &backoff.ZeroBackOff{},
+			deadline: time.Now().Add(-time.Second),
+			next:     backoff.Stop,
+		},
+		{
+			name:     "retries used up",
+			backOff:  &backoff.StopBackOff{},
+			deadline: time.Now().Add(time.Minute),
+			next:     backoff.Stop,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			b := deadlineBackOff{BackOff: test.backOff, deadline: test.deadline}
+
+			if next := b.NextBackOff(); next != test.next {
+				t.Errorf("expected %s, got %s", test.next, next)
+			}
+		})
+	}
+}
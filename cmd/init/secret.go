@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/cenkalti/backoff"
+	"github.com/ericchiang/k8s"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// readWrappedSecret waits for the controller to write the wrapped secret to the pod's Secret, reads it using the pod's
+// service account and marks the Secret as consumed, so that the controller deletes it.
+func readWrappedSecret(podName string, logger *logrus.Logger, result chan<- receivedSecret) {
+
+	client, err := k8s.NewInClusterClient()
+
+	if err != nil {
+		logger.Fatalf("Could not create kubernetes client: %s", err)
+	}
+
+	name := common.WrappedSecretName(podName)
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = 0
+	exp.MaxInterval = 10 * time.Second
+
+	var wrappedSecret common.WrappedSecretId
+
+	op := func() error {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		secret, err := client.CoreV1().GetSecret(ctx, name, client.Namespace)
+
+		if err != nil {
+			return errors.Wrapf(err, "could not get secret %s", name)
+		}
+
+		// The Secret was written for a previous init container. Wait for the controller to replace it.
+		if secret.GetMetadata().Annotations[common.ConsumedAnnotation] == "true" {
+			return errors.Errorf("secret %s was already consumed", name)
+		}
+
+		wrappedSecret = common.WrappedSecretId{}
+
+		if err := json.Unmarshal(secret.Data[common.WrappedSecretKey], &wrappedSecret); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "could not decode wrapped secret in secret %s", name))
+		}
+
+		if secret.Metadata.Annotations == nil {
+			secret.Metadata.Annotations = map[string]string{}
+		}
+
+		secret.Metadata.Annotations[common.ConsumedAnnotation] = "true"
+
+		if _, err := client.CoreV1().UpdateSecret(ctx, secret); err != nil {
+			logger.Errorf("Could not mark secret %s as consumed. It is deleted once the wrapped tokens expire: %s", name, err)
+		}
+
+		return nil
+	}
+
+	notify := func(err error, wait time.Duration) {
+		logger.Debugf("Could not read wrapped secret, retrying in %s: %s", wait, err)
+	}
+
+	if err := backoff.RetryNotify(op, exp, notify); err != nil {
+		logger.Fatal(err)
+	}
+
+	result <- receivedSecret{wrappedSecretId: wrappedSecret}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	initMode    = "init"
+	sidecarMode = "sidecar"
+
+	defaultRefreshInterval = 5 * time.Minute
+	minimumRefreshInterval = 10 * time.Second
+	failedRefreshInterval  = 1 * time.Minute
+)
+
+// sidecar keeps the auth token renewed and refreshes the certificate and rendered templates before they expire.
+type sidecar struct {
+	client          *api.Client
+	token           authToken
+	tokenExpiration time.Time
+	childTokens     []*childToken
+	credentialsPath string
+	output          outputConfig
+	files           fileConfig
+	ip              net.IP
+	pki             *pkiConfig
+	templates       []secretTemplate
+	logger          *logrus.Logger
+	shutdown        chan struct{}
+}
+
+// run blocks until the sidecar receives SIGINT or SIGTERM, at which point the auth token is revoked and the process
+// exits.
+func (s *sidecar) run(certificateExpiration time.Time, templatesExpiration time.Time) {
+
+	s.shutdown = make(chan struct{})
+	s.tokenExpiration = time.Now().Add(time.Duration(s.token.LeaseDuration) * time.Second)
+
+	if s.token.Renewable {
+		s.schedule("auth token", refreshInterval(s.tokenExpiration), s.renewToken)
+	} else {
+		s.logger.Errorf("The auth token is not renewable and will expire at %s.", s.tokenExpiration)
+	}
+
+	for _, child := range s.childTokens {
+
+		if child.token.Renewable {
+			s.schedule("child token ("+child.name+")", refreshInterval(child.expiration), s.renewChildToken(child))
+		} else {
+			s.logger.Errorf("The child token (%s) is not renewable and will expire at %s.", child.name, child.expiration)
+		}
+	}
+
+	if s.pki != nil {
+		s.schedule("certificate", refreshInterval(certificateExpiration), s.renewCertificate)
+	}
+
+	if len(s.templates) > 0 {
+		s.schedule("templates", refreshInterval(templatesExpiration), s.renderTemplates)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigs
+
+	close(s.shutdown)
+
+	if err := s.client.Auth().Token().RevokeSelf(""); err != nil {
+		s.logger.Fatalf("Could not revoke auth token: %s", err)
+	}
+
+	s.logger.Debug("Revoked the vault token. Exiting.")
+	os.Exit(0)
+}
+
+// schedule runs a refresh operation in the background. The operation is retried with backoff and returns how long to
+// wait before the next refresh.
+func (s *sidecar) schedule(name string, next time.Duration, refresh func() (time.Duration, error)) {
+
+	go func() {
+
+		timer := time.NewTimer(next)
+
+		for {
+			select {
+			case <-timer.C:
+
+				exp := backoff.NewExponentialBackOff()
+				exp.MaxElapsedTime = calculateMaxElapsedTime(next)
+
+				op := func() error {
+					var err error
+					next, err = refresh()
+					return err
+				}
+
+				if err := backoff.Retry(op, exp); err != nil {
+					s.logger.Errorf("Could not refresh %s: %s", name, err)
+					next = failedRefreshInterval
+				} else {
+					s.logger.Debugf("Refreshed %s. Next refresh in %s.", name, next)
+				}
+
+				timer.Reset(next)
+
+			case <-s.shutdown:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (s *sidecar) renewToken() (time.Duration, error) {
+
+	if time.Now().After(s.tokenExpiration) {
+		s.logger.Fatalf("The auth token expired at %s before it could be renewed.", s.tokenExpiration)
+	}
+
+	secret, err := s.client.Auth().Token().RenewSelf(0)
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error renewing auth token")
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return 0, errors.New("renewed auth token is empty")
+	}
+
+	s.token.LeaseDuration = secret.Auth.LeaseDuration
+	s.token.Renewable = secret.Auth.Renewable
+	s.tokenExpiration = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+
+	// Only the json format contains the lease duration, the other formats do not change.
+	if s.output.has(jsonFormat) {
+
+		b, err := json.Marshal(s.token)
+
+		if err != nil {
+			return 0, errors.Wrap(err, "could not marshal auth token to JSON")
+		}
+
+		if err = s.files.write(filepath.Join(s.credentialsPath, s.output.jsonFileName("vault-token")), b); err != nil {
+			return 0, errors.Wrap(err, "could not write auth token")
+		}
+	}
+
+	return refreshInterval(s.tokenExpiration), nil
+}
+
+// renewChildToken returns a refresh operation that renews a child token. Vault does not renew a child token beyond the
+// expiration of the auth token, which is renewed separately.
+func (s *sidecar) renewChildToken(child *childToken) func() (time.Duration, error) {
+
+	return func() (time.Duration, error) {
+
+		if time.Now().After(child.expiration) {
+			s.logger.Fatalf("The child token (%s) expired at %s before it could be renewed.", child.name, child.expiration)
+		}
+
+		secret, err := s.client.Auth().Token().RenewTokenAsSelf(child.token.ClientToken, 0)
+
+		if err != nil {
+			return 0, errors.Wrapf(err, "error renewing child token (%s)", child.name)
+		}
+
+		if secret == nil || secret.Auth == nil {
+			return 0, errors.Errorf("renewed child token (%s) is empty", child.name)
+		}
+
+		child.token.LeaseDuration = secret.Auth.LeaseDuration
+		child.token.Renewable = secret.Auth.Renewable
+		child.expiration = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+
+		if s.output.has(jsonFormat) {
+
+			b, err := json.Marshal(child.token)
+
+			if err != nil {
+				return 0, errors.Wrapf(err, "could not marshal child token (%s) to JSON", child.name)
+			}
+
+			if err = s.files.write(filepath.Join(s.credentialsPath, s.output.jsonFileName("vault-token")+"-"+child.name), b); err != nil {
+				return 0, errors.Wrapf(err, "could not write child token (%s)", child.name)
+			}
+		}
+
+		return refreshInterval(child.expiration), nil
+	}
+}
+
+func (s *sidecar) renewCertificate() (time.Duration, error) {
+
+	cert, err := issueCertificate(s.client, s.pki)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = writeCertificate(s.credentialsPath, cert, s.files); err != nil {
+		return 0, err
+	}
+
+	return refreshInterval(cert.Expiration), nil
+}
+
+func (s *sidecar) renderTemplates() (time.Duration, error) {
+
+	renderer := newTemplateRenderer(s.client, s.ip)
+
+	if err := renderer.render(s.credentialsPath, s.templates, s.files); err != nil {
+		return 0, err
+	}
+
+	return refreshInterval(renderer.expiration), nil
+}
+
+// refreshInterval returns the time to wait before refreshing something that expires at the given time. Refreshes
+// happen half way to the expiration, so that there is enough time to retry on failure.
+func refreshInterval(expiration time.Time) time.Duration {
+
+	if expiration.IsZero() {
+		return defaultRefreshInterval
+	}
+
+	interval := time.Until(expiration) / 2
+
+	if interval < minimumRefreshInterval {
+		return minimumRefreshInterval
+	}
+
+	return interval
+}
+
+// calculateMaxElapsedTime calculates the optimal maximum time for the backoff algorithm
+func calculateMaxElapsedTime(t time.Duration) time.Duration {
+
+	if t >= 10*time.Second {
+		return t - (10 * time.Second)
+	}
+
+	return time.Duration(float64(t) * 0.5)
+}
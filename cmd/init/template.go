@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+type secretTemplate struct {
+	Name     string
+	Mode     os.FileMode
+	Template *template.Template
+}
+
+type templateCertificate struct {
+	Certificate string
+	PrivateKey  string
+	IssuingCA   string
+}
+
+// templateRenderer renders secret templates using a Vault token. Secrets and certificates are cached, so that using the
+// same path or certificate more than once in a render results in a single request to Vault.
+type templateRenderer struct {
+	client       *api.Client
+	ip           net.IP
+	secrets      map[string]*api.Secret
+	certificates map[string]templateCertificate
+
+	// expiration is the earliest time a secret lease or certificate used by the render expires.
+	expiration time.Time
+}
+
+// templatesFromEnvironment parses the templates in TEMPLATES_PATH. Each file ending in .tmpl is rendered to a file
+// with the same name, without the .tmpl extension.
+func templatesFromEnvironment() ([]secretTemplate, error) {
+
+	templates := []secretTemplate{}
+
+	templatesPath := os.Getenv("TEMPLATES_PATH")
+
+	if templatesPath == "" {
+		return templates, nil
+	}
+
+	defaultMode, err := parseFileMode(os.Getenv("TEMPLATE_FILE_MODE"), defaultFileMode)
+
+	if err != nil {
+		return templates, errors.Wrap(err, "invalid TEMPLATE_FILE_MODE")
+	}
+
+	modes := map[string]os.FileMode{}
+
+	for _, fileMode := range splitList(os.Getenv("TEMPLATE_FILE_MODES")) {
+
+		parts := strings.SplitN(fileMode, "=", 2)
+
+		if len(parts) != 2 {
+			return templates, errors.Errorf("invalid TEMPLATE_FILE_MODES entry (%s). Entries must be in the form name=mode", fileMode)
+		}
+
+		mode, err := parseFileMode(parts[1], defaultMode)
+
+		if err != nil {
+			return templates, errors.Wrapf(err, "invalid TEMPLATE_FILE_MODES entry (%s)", fileMode)
+		}
+
+		modes[strings.TrimSpace(parts[0])] = mode
+	}
+
+	files, err := filepath.Glob(filepath.Join(templatesPath, "*.tmpl"))
+
+	if err != nil {
+		return templates, errors.Wrapf(err, "could not list templates in %s", templatesPath)
+	}
+
+	for _, file := range files {
+
+		b, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return templates, errors.Wrapf(err, "could not read template (%s)", file)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+
+		t, err := template.New(name).Option("missingkey=error").Funcs((&templateRenderer{}).funcs()).Parse(string(b))
+
+		if err != nil {
+			return templates, errors.Wrapf(err, "could not parse template (%s)", file)
+		}
+
+		mode, ok := modes[name]
+
+		if !ok {
+			mode = defaultMode
+		}
+
+		templates = append(templates, secretTemplate{
+			Name:     name,
+			Mode:     mode,
+			Template: t,
+		})
+	}
+
+	return templates, nil
+}
+
+func newTemplateRenderer(client *api.Client, ip net.IP) *templateRenderer {
+	return &templateRenderer{
+		client:       client,
+		ip:           ip,
+		secrets:      map[string]*api.Secret{},
+		certificates: map[string]templateCertificate{},
+	}
+}
+
+func (r *templateRenderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"secret": r.secret,
+		"pki":    r.pki,
+	}
+}
+
+// secret reads a secret from Vault. If no key is given, all the secret's data is returned. Secrets from version 2 of
+// the key/value backend are unnested, so that keys can be used directly.
+func (r *templateRenderer) secret(path string, keys ...string) (interface{}, error) {
+
+	s, ok := r.secrets[path]
+
+	if !ok {
+		var err error
+
+		s, err = r.client.Logical().Read(path)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read secret (%s)", path)
+		}
+
+		if s == nil {
+			return nil, errors.Errorf("secret (%s) does not exist", path)
+		}
+
+		r.secrets[path] = s
+
+		if s.LeaseDuration > 0 {
+			r.expires(time.Now().Add(time.Duration(s.LeaseDuration) * time.Second))
+		}
+	}
+
+	data := s.Data
+
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+
+	if len(keys) == 0 {
+		return data, nil
+	}
+
+	if len(keys) > 1 {
+		return nil, errors.Errorf("only one key can be requested from secret (%s)", path)
+	}
+
+	value, ok := data[keys[0]]
+
+	if !ok {
+		return nil, errors.Errorf("secret (%s) does not contain the key (%s)", path, keys[0])
+	}
+
+	return value, nil
+}
+
+// pki issues a certificate from a Vault PKI backend. Any additional names are used as DNS subject alternative names.
+func (r *templateRenderer) pki(backend string, role string, commonName string, altNames ...string) (templateCertificate, error) {
+
+	key := fmt.Sprintf("%s/%s/%s/%s", backend, role, commonName, strings.Join(altNames, ","))
+
+	if cert, ok := r.certificates[key]; ok {
+		return cert, nil
+	}
+
+	cert, err := issueCertificate(r.client, &pkiConfig{
+		Backend:    strings.Trim(backend, "/"),
+		Role:       role,
+		CommonName: commonName,
+		AltNames:   altNames,
+		IPSANs:     []string{r.ip.String()},
+	})
+
+	if err != nil {
+		return templateCertificate{}, err
+	}
+
+	r.expires(cert.Expiration)
+
+	r.certificates[key] = templateCertificate{
+		Certificate: string(cert.Certificate),
+		PrivateKey:  string(cert.PrivateKey),
+		IssuingCA:   string(cert.IssuingCA),
+	}
+
+	return r.certificates[key], nil
+}
+
+func (r *templateRenderer) expires(expiration time.Time) {
+
+	if r.expiration.IsZero() || expiration.Before(r.expiration) {
+		r.expiration = expiration
+	}
+}
+
+func (r *templateRenderer) render(credentialsPath string, templates []secretTemplate, files fileConfig) error {
+
+	for _, t := range templates {
+
+		var buf bytes.Buffer
+
+		if err := template.Must(t.Template.Clone()).Funcs(r.funcs()).Execute(&buf, nil); err != nil {
+			return errors.Wrapf(err, "could not render template (%s)", t.Name)
+		}
+
+		path := filepath.Join(credentialsPath, t.Name)
+
+		if err := files.writeWithMode(path, buf.Bytes(), t.Mode); err != nil {
+			return errors.Wrapf(err, "could not write rendered template to path (%s)", path)
+		}
+	}
+
+	return nil
+}
+
+func parseFileMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+
+	if mode == "" {
+		return defaultMode, nil
+	}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+
+	if err != nil {
+		return 0, errors.Errorf("file mode (%s) must be an octal number, for example 0440", mode)
+	}
+
+	return os.FileMode(m), nil
+}
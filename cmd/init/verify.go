@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// creationTimeTolerance is how far the creation time of a wrapping token may be from the one in the payload, as the
+// controller and Vault may format it with a different precision.
+const creationTimeTolerance = time.Second
+
+// expectedRoles are the roles the init container expects wrapping tokens to be created for. They are set from the pod's
+// annotations using the Downward API, so that the roles wrapping tokens are checked against never come from the
+// payload. mountPath is empty if the pod's mount path is not set, in which case the one in the payload is used.
+type expectedRoles struct {
+	role      string
+	tokenRole string
+	appRoles  map[string]string
+	mountPath string
+}
+
+// expectedRolesFromEnvironment reads the expected roles from VAULT_APPROLE, VAULT_TOKEN_ROLE, VAULT_APPROLES and
+// VAULT_APPROLE_MOUNT_PATH.
+func expectedRolesFromEnvironment() (expectedRoles, error) {
+
+	expected := expectedRoles{
+		role:      strings.TrimSpace(os.Getenv("VAULT_APPROLE")),
+		tokenRole: strings.TrimSpace(os.Getenv("VAULT_TOKEN_ROLE")),
+		appRoles:  map[string]string{},
+		mountPath: strings.Trim(os.Getenv("VAULT_APPROLE_MOUNT_PATH"), "/"),
+	}
+
+	for _, pair := range splitList(os.Getenv("VAULT_APPROLES")) {
+
+		parts := strings.SplitN(pair, "=", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return expected, errors.Errorf("invalid VAULT_APPROLES entry (%s). It must be a name=role pair", pair)
+		}
+
+		expected.appRoles[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return expected, nil
+}
+
+// configured returns whether any of the expected roles are set. Wrapping tokens are only verified if they are.
+func (e expectedRoles) configured() bool {
+	return e.role != "" || e.tokenRole != "" || len(e.appRoles) > 0
+}
+
+// appRoleMountPath returns the expected mount path, or the mount path in the payload if the pod's is not set.
+func (e expectedRoles) appRoleMountPath(payloadMountPath string) string {
+
+	if e.mountPath != "" {
+		return e.mountPath
+	}
+
+	if payloadMountPath != "" {
+		return strings.Trim(payloadMountPath, "/")
+	}
+
+	return common.DefaultAppRoleMountPath
+}
+
+// verifyWrappedSecretId looks up every wrapping token in the payload without unwrapping it and checks that Vault
+// created it for the expected role at the time and with the TTL in the payload. A token that does not match may have
+// been substituted, so it must not be unwrapped. Nothing is verified if no expected roles are set.
+func verifyWrappedSecretId(w common.WrappedSecretId, expected expectedRoles, retry retrier) error {
+
+	if !expected.configured() {
+		return nil
+	}
+
+	client, err := getAPIClient(w.VaultAddr, w.VaultCAs)
+
+	if err != nil {
+		return errors.Wrap(err, "error creating vault client")
+	}
+
+	switch w.GetDeliveryType() {
+	case common.TokenDelivery:
+
+		if expected.tokenRole == "" {
+			return errors.New("received a wrapped token, but VAULT_TOKEN_ROLE is not set")
+		}
+
+		err = verifyWrappingToken(client, retry, w.SecretID, fmt.Sprintf("auth/token/create/%s", expected.tokenRole), w.CreationTime, w.TTL)
+
+		if err != nil {
+			return errors.Wrap(err, "invalid wrapped token")
+		}
+
+	case common.AppRolesDelivery:
+		for _, appRole := range w.AppRoles {
+
+			role, ok := expected.appRoles[appRole.Name]
+
+			if !ok {
+				return errors.Errorf("received a wrapped secret_id for AppRole (%s), which is not in VAULT_APPROLES", appRole.Name)
+			}
+
+			creationPath := fmt.Sprintf("auth/%s/role/%s/secret-id", expected.appRoleMountPath(appRole.AppRoleMountPath), role)
+
+			err = verifyWrappingToken(client, retry, appRole.SecretID, creationPath, appRole.CreationTime, appRole.TTL)
+
+			if err != nil {
+				return errors.Wrapf(err, "invalid wrapped secret_id for AppRole (%s)", appRole.Name)
+			}
+		}
+
+	default:
+
+		if expected.role == "" {
+			return errors.New("received a wrapped secret_id, but VAULT_APPROLE is not set")
+		}
+
+		creationPath := fmt.Sprintf("auth/%s/role/%s/secret-id", expected.appRoleMountPath(w.AppRoleMountPath), expected.role)
+
+		err = verifyWrappingToken(client, retry, w.SecretID, creationPath, w.CreationTime, w.TTL)
+
+		if err != nil {
+			return errors.Wrap(err, "invalid wrapped secret_id")
+		}
+	}
+
+	for _, secret := range w.Secrets {
+
+		err = verifyWrappingToken(client, retry, secret.Token, strings.Trim(secret.Path, "/"), secret.CreationTime, secret.TTL)
+
+		if err != nil {
+			return errors.Wrapf(err, "invalid wrapped secret (%s)", secret.Name)
+		}
+	}
+
+	return nil
+}
+
+// verifyWrappingToken checks that a wrapping token was created for creationPath at creationTime with ttl using
+// sys/wrapping/lookup, which does not use up the token.
+func verifyWrappingToken(client *api.Client, retry retrier, token string, creationPath string, creationTime time.Time, ttl int) error {
+
+	client.SetToken(token)
+
+	var secret *api.Secret
+
+	err := retry.do("look up wrapping token", func() (err error) {
+		secret, err = client.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+			"token": token,
+		})
+		return err
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "could not look up wrapping token")
+	}
+
+	if secret == nil {
+		return errors.New("wrapping token does not exist")
+	}
+
+	actualPath, _ := secret.Data["creation_path"].(string)
+
+	if actualPath != creationPath {
+		return errors.Errorf("wrapping token was created for %s instead of %s", actualPath, creationPath)
+	}
+
+	actualTimeStr, _ := secret.Data["creation_time"].(string)
+
+	actualTime, err := time.Parse(time.RFC3339Nano, actualTimeStr)
+
+	if err != nil {
+		return errors.Wrapf(err, "invalid creation time (%s)", actualTimeStr)
+	}
+
+	if d := actualTime.Sub(creationTime); d > creationTimeTolerance || d < -creationTimeTolerance {
+		return errors.Errorf("wrapping token was created at %s instead of %s", actualTime, creationTime)
+	}
+
+	actualTTL, err := parseInt(secret.Data["creation_ttl"])
+
+	if err != nil {
+		return errors.Wrap(err, "invalid creation TTL")
+	}
+
+	if actualTTL != ttl {
+		return errors.Errorf("wrapping token was created with a TTL of %ds instead of %ds", actualTTL, ttl)
+	}
+
+	return nil
+}
+
+// parseInt returns a number decoded by the Vault client, which decodes numbers as json.Number.
+func parseInt(value interface{}) (int, error) {
+
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err
+
+	case float64:
+		return int(v), nil
+
+	case int:
+		return v, nil
+	}
+
+	return 0, errors.Errorf("%v is not a number", value)
+}
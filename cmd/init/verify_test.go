@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Boostport/kubernetes-vault/common"
+	"github.com/sirupsen/logrus"
+)
+
+// testLookupServer responds to sys/wrapping/lookup like Vault does for a wrapping token with the given properties.
+func testLookupServer(t *testing.T, creationPath string, creationTime time.Time, creationTTL int) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/v1/sys/wrapping/lookup" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"creation_path": creationPath,
+				"creation_time": creationTime.Format(time.RFC3339Nano),
+				"creation_ttl":  creationTTL,
+			},
+		})
+	}))
+}
+
+func testWrappedSecretId(vaultAddr string) common.WrappedSecretId {
+	return common.WrappedSecretId{
+		Role:         "sample-app",
+		SecretID:     "b7f2a6c5-0f6e-4d7c-9a51-3b0e1c5d2a11",
+		CreationTime: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+		TTL:          60,
+		VaultAddr:    vaultAddr,
+		DeliveryType: common.SecretIdDelivery,
+	}
+}
+
+func TestVerifyWrappingToken(t *testing.T) {
+
+	creationTime := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	creationPath := "auth/approle/role/sample-app/secret-id"
+
+	tests := []struct {
+		name         string
+		creationPath string
+		creationTime time.Time
+		ttl          int
+		err          string
+	}{
+		{
+			name:         "match",
+			creationPath: creationPath,
+			creationTime: creationTime,
+			ttl:          60,
+		},
+		{
+			name:         "creation time within the tolerance",
+			creationPath: creationPath,
+			creationTime: creationTime.Add(500 * time.Millisecond),
+			ttl:          60,
+		},
+		{
+			name:         "path mismatch",
+			creationPath: "auth/approle/role/other-app/secret-id",
+			creationTime: creationTime,
+			ttl:          60,
+			err:          "was created for",
+		},
+		{
+			name:         "creation time mismatch",
+			creationPath: creationPath,
+			creationTime: creationTime.Add(time.Minute),
+			ttl:          60,
+			err:          "was created at",
+		},
+		{
+			name:         "ttl mismatch",
+			creationPath: creationPath,
+			creationTime: creationTime,
+			ttl:          3600,
+			err:          "TTL",
+		},
+	}
+
+	server := testLookupServer(t, creationPath, creationTime, 60)
+	defer server.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			client, err := getAPIClient(server.URL, nil)
+
+			if err != nil {
+				t.Fatalf("could not create client: %s", err)
+			}
+
+			err = verifyWrappingToken(client, retrier{logger: logrus.New()}, "token", test.creationPath, test.creationTime, test.ttl)
+
+			if test.err == "" && err != nil {
+				t.Errorf("expected the wrapping token to be accepted, got: %s", err)
+			}
+
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyWrappedSecretIdWithoutExpectedRoles(t *testing.T) {
+
+	// No request may be made, as nothing is verified.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	w := testWrappedSecretId(server.URL)
+
+	if err := verifyWrappedSecretId(w, expectedRoles{}, retrier{logger: logrus.New()}); err != nil {
+		t.Errorf("expected no error, got: %s", err)
+	}
+}
+
+func TestAppRoleMountPath(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected expectedRoles
+		payload  string
+		result   string
+	}{
+		{
+			name:     "pod mount path",
+			expected: expectedRoles{mountPath: "kubernetes-approle"},
+			payload:  "other",
+			result:   "kubernetes-approle",
+		},
+		{
+			name:    "payload mount path",
+			payload: "/kubernetes-approle/",
+			result:  "kubernetes-approle",
+		},
+		{
+			name:   "default mount path",
+			result: "approle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if result := test.expected.appRoleMountPath(test.payload); result != test.result {
+				t.Errorf("expected %s, got %s", test.result, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// wrapTTLFromEnvironment returns WRAP_TOKEN_TTL, the TTL of the wrapping token written instead of the auth token. It
+// is 0 if the auth token is written as is.
+func wrapTTLFromEnvironment() (time.Duration, error) {
+
+	ttl := os.Getenv("WRAP_TOKEN_TTL")
+
+	if ttl == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(ttl)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid WRAP_TOKEN_TTL (%s)", ttl)
+	}
+
+	if d < time.Second {
+		return 0, errors.Errorf("WRAP_TOKEN_TTL (%s) must be at least 1s", ttl)
+	}
+
+	return d, nil
+}
+
+// wrapCredentials wraps the auth token in the credentials using sys/wrapping/wrap, so that only the wrapping token is
+// written. The application unwraps it exactly once, and Vault's audit log shows any other attempt to unwrap it.
+func wrapCredentials(c credentialsFile, vaultCAs []byte, ttl time.Duration, retry retrier) (credentialsFile, error) {
+
+	client, err := getAPIClient(c.token.VaultAddr, vaultCAs)
+
+	if err != nil {
+		return credentialsFile{}, errors.Wrap(err, "error creating vault client")
+	}
+
+	var info wrapInfo
+
+	err = retry.do("wrap auth token", func() (err error) {
+		info, err = wrapToken(client, c.token, ttl)
+		return err
+	})
+
+	if err != nil {
+		return credentialsFile{}, err
+	}
+
+	return credentialsFile{
+		response: wrappedToken{
+			WrappedToken: info.Token,
+			VaultAddr:    c.token.VaultAddr,
+			TTL:          info.TTL,
+			info:         info,
+		},
+		fileName:  "vault-wrapped-token",
+		tokenType: "wrapped auth token",
+	}, nil
+}
+
+// wrapInfo is the wrap_info of a wrapped response. Unlike api.SecretWrapInfo, it includes the accessor of the wrapping
+// token, so that it can be written in the format of a Vault Agent file sink that wraps its token.
+type wrapInfo struct {
+	Token           string    `json:"token"`
+	Accessor        string    `json:"accessor"`
+	TTL             int       `json:"ttl"`
+	CreationTime    time.Time `json:"creation_time"`
+	CreationPath    string    `json:"creation_path"`
+	WrappedAccessor string    `json:"wrapped_accessor"`
+}
+
+func wrapToken(client *api.Client, token authToken, ttl time.Duration) (wrapInfo, error) {
+	client.SetToken(token.ClientToken)
+
+	r := client.NewRequest("PUT", "/v1/sys/wrapping/wrap")
+	r.WrapTTL = ttl.String()
+
+	err := r.SetJSONBody(map[string]interface{}{
+		"token":          token.ClientToken,
+		"accessor":       token.Accessor,
+		"lease_duration": token.LeaseDuration,
+		"renewable":      token.Renewable,
+	})
+
+	if err != nil {
+		return wrapInfo{}, errors.Wrap(err, "could not encode request data")
+	}
+
+	resp, err := client.RawRequest(r)
+
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	if err != nil {
+		return wrapInfo{}, errors.Wrap(err, "could not wrap auth token")
+	}
+
+	var secret struct {
+		WrapInfo *wrapInfo `json:"wrap_info"`
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return wrapInfo{}, errors.Wrap(err, "could not parse wrapped auth token")
+	}
+
+	if secret.WrapInfo == nil {
+		return wrapInfo{}, errors.New("vault did not wrap the auth token")
+	}
+
+	return *secret.WrapInfo, nil
+}
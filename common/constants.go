@@ -1,5 +1,27 @@
 package common
 
+import (
+	"regexp"
+	"strings"
+)
+
 const (
-	InitContainerPort = 50000
+	InitContainerPort       = 50000
+	DefaultAppRoleMountPath = "approle"
+
+	// PullPath is the controller endpoint init containers using pull delivery request their wrapped secret from.
+	PullPath = "/v1/secret"
 )
+
+var nonAlphanumericRegex = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// EnvSuffix converts a name into the form used in environment variables, for example LOG_SHIPPER for log-shipper.
+func EnvSuffix(name string) string {
+	return strings.ToUpper(nonAlphanumericRegex.ReplaceAllString(name, "_"))
+}
+
+// AppRoleIDEnv returns the environment variable of the init container holding the role_id for a named AppRole, for
+// example VAULT_ROLE_ID_LOG_SHIPPER for log-shipper.
+func AppRoleIDEnv(name string) string {
+	return "VAULT_ROLE_ID_" + EnvSuffix(name)
+}
@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestEnvSuffix(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		suffix string
+	}{
+		{name: "metrics", suffix: "METRICS"},
+		{name: "log-shipper", suffix: "LOG_SHIPPER"},
+		{name: "app.v2_db", suffix: "APP_V2_DB"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			if suffix := EnvSuffix(test.name); suffix != test.suffix {
+				t.Errorf("expected %s, got %s", test.suffix, suffix)
+			}
+		})
+	}
+}
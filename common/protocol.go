@@ -0,0 +1,214 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProtocolV1 is the unversioned WrappedSecretId POSTed to init containers that predate the handshake.
+	ProtocolV1 = 1
+
+	// ProtocolV2 is a Message with the protocol version and the WrappedSecretId as the payload.
+	ProtocolV2 = 2
+)
+
+// ErrUnsupportedVersion is the cause of the error returned by DecodeMessage if the protocol version is not supported.
+var ErrUnsupportedVersion = errors.New("protocol version is not supported")
+
+// ProtocolVersions are the protocol versions supported by this build, in ascending order.
+var ProtocolVersions = []int{ProtocolV1, ProtocolV2}
+
+// Capabilities an init container advertises in the handshake. Payloads that need a capability the init container
+// does not have are not pushed to it.
+const (
+	CapabilityTokens      = "tokens"
+	CapabilityAppRoles    = "approles"
+	CapabilitySecrets     = "secrets"
+	CapabilityPodIdentity = "pod_identity"
+)
+
+// Capabilities are the capabilities of this build.
+var Capabilities = []string{CapabilityTokens, CapabilityAppRoles, CapabilitySecrets, CapabilityPodIdentity}
+
+// Error codes returned by the init container in an ErrorResponse.
+const (
+	// PodIdentityMismatch is returned if a wrapped secret was intended for another pod, for example because the pod
+	// ip was reused by the time it was pushed.
+	PodIdentityMismatch = "pod_identity_mismatch"
+
+	// UnsupportedVersion is returned if the protocol version of a message is not supported.
+	UnsupportedVersion = "unsupported_version"
+
+	// WrappingTokenInvalid is returned if a wrapping token was not created for the expected path at the expected time,
+	// which means that it may have been intercepted and substituted.
+	WrappingTokenInvalid = "wrapping_token_invalid"
+
+	// UnwrapFailed is returned if a wrapped secret could not be unwrapped or used to log in, for example because it
+	// was already unwrapped, so that the controller pushes a new one.
+	UnwrapFailed = "unwrap_failed"
+)
+
+// ErrorResponse is the body of the response when the init container rejects a wrapped secret.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Handshake is returned by the init container on GET / to advertise the protocol versions and capabilities it
+// supports.
+type Handshake struct {
+	Versions     []int    `json:"versions"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// LegacyHandshake is assumed for init containers that predate the handshake. They only support ProtocolV1, and as it
+// is not known what they can do with a payload, they are assumed to have none of the capabilities.
+var LegacyHandshake = Handshake{
+	Versions:     []int{ProtocolV1},
+	Capabilities: []string{},
+}
+
+// NewHandshake returns the handshake for this build.
+func NewHandshake() Handshake {
+	return Handshake{
+		Versions:     ProtocolVersions,
+		Capabilities: Capabilities,
+	}
+}
+
+// Without returns the handshake without a capability, for init containers configured in a way that cannot use it.
+func (h Handshake) Without(capability string) Handshake {
+
+	capabilities := []string{}
+
+	for _, c := range h.Capabilities {
+		if c != capability {
+			capabilities = append(capabilities, c)
+		}
+	}
+
+	return Handshake{
+		Versions:     h.Versions,
+		Capabilities: capabilities,
+	}
+}
+
+// NegotiateVersion returns the highest protocol version supported by both sides.
+func (h Handshake) NegotiateVersion() (int, error) {
+
+	version := 0
+
+	for _, v := range h.Versions {
+		if supportsVersion(v) && v > version {
+			version = v
+		}
+	}
+
+	if version == 0 {
+		return 0, errors.Errorf("none of the protocol versions %v are supported", h.Versions)
+	}
+
+	return version, nil
+}
+
+// Supports returns whether the init container has a capability.
+func (h Handshake) Supports(capability string) bool {
+
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Message is the body POSTed to the init container from ProtocolV2 onwards.
+type Message struct {
+	Version int             `json:"version"`
+	Payload WrappedSecretId `json:"payload"`
+}
+
+// EncodeMessage encodes a wrapped secret using a protocol version.
+func EncodeMessage(version int, payload WrappedSecretId) ([]byte, error) {
+
+	if !supportsVersion(version) {
+		return nil, errors.Errorf("protocol version %d is not supported", version)
+	}
+
+	if version == ProtocolV1 {
+		return json.Marshal(payload)
+	}
+
+	return json.Marshal(Message{
+		Version: version,
+		Payload: payload,
+	})
+}
+
+// DecodeMessage decodes a wrapped secret sent using any supported protocol version. ProtocolV1 bodies do not have a
+// version.
+func DecodeMessage(b []byte) (WrappedSecretId, int, error) {
+
+	var message struct {
+		Version int             `json:"version"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	if err := json.Unmarshal(b, &message); err != nil {
+		return WrappedSecretId{}, 0, errors.Wrap(err, "could not decode message")
+	}
+
+	var payload WrappedSecretId
+
+	if message.Version == 0 {
+
+		if err := json.Unmarshal(b, &payload); err != nil {
+			return WrappedSecretId{}, 0, errors.Wrap(err, "could not decode message")
+		}
+
+		return payload, ProtocolV1, nil
+	}
+
+	if !supportsVersion(message.Version) {
+		return WrappedSecretId{}, message.Version, errors.Wrapf(ErrUnsupportedVersion, "version %d", message.Version)
+	}
+
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		return WrappedSecretId{}, message.Version, errors.Wrap(err, "could not decode payload")
+	}
+
+	return payload, message.Version, nil
+}
+
+// RequiredCapabilities returns the capabilities an init container needs to use a wrapped secret.
+func (w WrappedSecretId) RequiredCapabilities() []string {
+
+	capabilities := []string{}
+
+	switch w.GetDeliveryType() {
+	case TokenDelivery:
+		capabilities = append(capabilities, CapabilityTokens)
+	case AppRolesDelivery:
+		capabilities = append(capabilities, CapabilityAppRoles)
+	}
+
+	if len(w.Secrets) > 0 {
+		capabilities = append(capabilities, CapabilitySecrets)
+	}
+
+	return capabilities
+}
+
+func supportsVersion(version int) bool {
+
+	for _, v := range ProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,242 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func testWrappedSecretId() WrappedSecretId {
+	return WrappedSecretId{
+		Role:         "sample-app",
+		SecretID:     "b7f2a6c5-0f6e-4d7c-9a51-3b0e1c5d2a11",
+		CreationTime: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC),
+		TTL:          60,
+		VaultAddr:    "https://vault:8200",
+		DeliveryType: SecretIdDelivery,
+		PodNamespace: "default",
+		PodName:      "sample-app-1",
+		PodUID:       "a3d4c1b2-94c1-11e7-8b3a-0800270a1f3c",
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+
+	payload := testWrappedSecretId()
+
+	v1, err := json.Marshal(payload)
+
+	if err != nil {
+		t.Fatalf("could not encode payload: %s", err)
+	}
+
+	v2, err := json.Marshal(Message{Version: ProtocolV2, Payload: payload})
+
+	if err != nil {
+		t.Fatalf("could not encode message: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		body        []byte
+		version     int
+		payload     WrappedSecretId
+		unsupported bool
+		invalid     bool
+	}{
+		{
+			name:    "v1 body without a version",
+			body:    v1,
+			version: ProtocolV1,
+			payload: payload,
+		},
+		{
+			name:    "v2 message",
+			body:    v2,
+			version: ProtocolV2,
+			payload: payload,
+		},
+		{
+			name:        "unknown version",
+			body:        []byte(`{"version": 99, "payload": {}}`),
+			version:     99,
+			unsupported: true,
+		},
+		{
+			name:    "invalid json",
+			body:    []byte(`{"version":`),
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+
+		decoded, version, err := DecodeMessage(test.body)
+
+		if test.unsupported {
+
+			if errors.Cause(err) != ErrUnsupportedVersion {
+				t.Errorf("%s: expected ErrUnsupportedVersion, got %v", test.name, err)
+			}
+
+		} else if test.invalid {
+
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+
+			continue
+
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if version != test.version {
+			t.Errorf("%s: expected version %d, got %d", test.name, test.version, version)
+		}
+
+		if !reflect.DeepEqual(decoded, test.payload) {
+			t.Errorf("%s: expected payload %+v, got %+v", test.name, test.payload, decoded)
+		}
+	}
+}
+
+func TestEncodeMessageV1RoundTrip(t *testing.T) {
+
+	payload := testWrappedSecretId()
+
+	body, err := EncodeMessage(ProtocolV1, payload)
+
+	if err != nil {
+		t.Fatalf("could not encode message: %s", err)
+	}
+
+	// Init containers that predate the handshake decode the body as a WrappedSecretId.
+	var legacy WrappedSecretId
+
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		t.Fatalf("a legacy init container could not decode the message: %s", err)
+	}
+
+	if !reflect.DeepEqual(legacy, payload) {
+		t.Errorf("expected a legacy init container to decode %+v, got %+v", payload, legacy)
+	}
+
+	decoded, version, err := DecodeMessage(body)
+
+	if err != nil {
+		t.Fatalf("could not decode message: %s", err)
+	}
+
+	if version != ProtocolV1 {
+		t.Errorf("expected version %d, got %d", ProtocolV1, version)
+	}
+
+	if !reflect.DeepEqual(decoded, payload) {
+		t.Errorf("expected payload %+v, got %+v", payload, decoded)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		versions []int
+		version  int
+		err      bool
+	}{
+		{name: "legacy", versions: LegacyHandshake.Versions, version: ProtocolV1},
+		{name: "current", versions: ProtocolVersions, version: ProtocolV2},
+		{name: "newer init container", versions: []int{ProtocolV1, ProtocolV2, 99}, version: ProtocolV2},
+		{name: "unordered", versions: []int{ProtocolV2, ProtocolV1}, version: ProtocolV2},
+		{name: "only unknown versions", versions: []int{99}, err: true},
+		{name: "no versions", versions: []int{}, err: true},
+	}
+
+	for _, test := range tests {
+
+		version, err := Handshake{Versions: test.versions}.NegotiateVersion()
+
+		if test.err {
+
+			if err == nil {
+				t.Errorf("%s: expected an error, got version %d", test.name, version)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if version != test.version {
+			t.Errorf("%s: expected version %d, got %d", test.name, test.version, version)
+		}
+	}
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		secret       WrappedSecretId
+		capabilities []string
+	}{
+		{
+			name:         "secret_id",
+			secret:       WrappedSecretId{DeliveryType: SecretIdDelivery},
+			capabilities: []string{},
+		},
+		{
+			name:         "secret_id from a controller without delivery types",
+			secret:       WrappedSecretId{},
+			capabilities: []string{},
+		},
+		{
+			name:         "token",
+			secret:       WrappedSecretId{DeliveryType: TokenDelivery},
+			capabilities: []string{CapabilityTokens},
+		},
+		{
+			name:         "approles",
+			secret:       WrappedSecretId{DeliveryType: AppRolesDelivery},
+			capabilities: []string{CapabilityAppRoles},
+		},
+		{
+			name:         "secret_id with secrets",
+			secret:       WrappedSecretId{Secrets: []WrappedSecret{{Name: "db"}}},
+			capabilities: []string{CapabilitySecrets},
+		},
+		{
+			name:         "token with secrets",
+			secret:       WrappedSecretId{DeliveryType: TokenDelivery, Secrets: []WrappedSecret{{Name: "db"}}},
+			capabilities: []string{CapabilityTokens, CapabilitySecrets},
+		},
+	}
+
+	for _, test := range tests {
+
+		capabilities := test.secret.RequiredCapabilities()
+
+		if !reflect.DeepEqual(capabilities, test.capabilities) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.capabilities, capabilities)
+		}
+
+		for _, capability := range capabilities {
+
+			if LegacyHandshake.Supports(capability) {
+				t.Errorf("%s: legacy init containers must not support %s", test.name, capability)
+			}
+
+			if !NewHandshake().Supports(capability) {
+				t.Errorf("%s: this build does not support %s", test.name, capability)
+			}
+		}
+	}
+}
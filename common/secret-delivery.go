@@ -0,0 +1,15 @@
+package common
+
+const (
+	// WrappedSecretKey is the key of the wrapped secret in the Kubernetes Secret written for pods using secret delivery.
+	WrappedSecretKey = "wrappedSecret"
+
+	// ConsumedAnnotation is set to "true" on the Kubernetes Secret by the init container once it has read the wrapped
+	// secret, so that the controller deletes it.
+	ConsumedAnnotation = "vault.boostport.com/consumed"
+)
+
+// WrappedSecretName returns the name of the Kubernetes Secret the wrapped secret for a pod is written to.
+func WrappedSecretName(podName string) string {
+	return podName + "-vault"
+}
@@ -1,35 +1,230 @@
 package common
 
 import (
+	"regexp"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
-type WrappedSecretId struct {
-	SecretID     string    `json:"token"`
+const (
+	SecretIdDelivery = "secret_id"
+	TokenDelivery    = "token"
+	AppRolesDelivery = "approles"
+)
+
+var secretNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// WrappedSecret is a wrapped response for an arbitrary Vault path requested by a pod.
+type WrappedSecret struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	Token        string    `json:"token"`
 	CreationTime time.Time `json:"creationTime"`
 	TTL          int       `json:"ttl"`
-	VaultAddr    string    `json:"vaultAddr"`
-	VaultCAs     []byte    `json:"vaultCAs"`
 }
 
-func (w WrappedSecretId) Validate() error {
+func (w WrappedSecret) Validate() error {
+
+	if !ValidSecretName(w.Name) {
+		return errors.Errorf("Secret name (%s) is invalid.", w.Name)
+	}
+
+	if w.Token == "" {
+		return errors.Errorf("Token for secret (%s) is empty.", w.Name)
+	}
+
+	if w.CreationTime.Add(time.Duration(w.TTL) * time.Second).Before(time.Now()) {
+		return errors.Errorf("Token for secret (%s) is expired.", w.Name)
+	}
+
+	return nil
+}
+
+// ValidSecretName returns whether a secret name can be safely used as a file name.
+func ValidSecretName(name string) bool {
+	return secretNameRegex.MatchString(name)
+}
+
+// WrappedAppRole is a wrapped secret_id for one of several AppRoles requested by a pod.
+type WrappedAppRole struct {
+	Name             string    `json:"name"`
+	Role             string    `json:"role"`
+	SecretID         string    `json:"token"`
+	CreationTime     time.Time `json:"creationTime"`
+	TTL              int       `json:"ttl"`
+	AppRoleMountPath string    `json:"appRoleMountPath"`
+	RoleID           string    `json:"roleId"`
+}
+
+func (w WrappedAppRole) Validate() error {
+
+	if !ValidSecretName(w.Name) {
+		return errors.Errorf("AppRole name (%s) is invalid.", w.Name)
+	}
 
 	if w.SecretID == "" {
-		return errors.New("Token is empty.")
+		return errors.Errorf("Token for AppRole (%s) is empty.", w.Name)
+	}
+
+	if w.AppRoleMountPath == "" {
+		return errors.Errorf("Mount path for AppRole (%s) is empty.", w.Name)
 	}
 
-	if w.CreationTime.IsZero() {
-		return errors.New("CreationTime is invalid.")
+	if w.CreationTime.Add(time.Duration(w.TTL) * time.Second).Before(time.Now()) {
+		return errors.Errorf("Token for AppRole (%s) is expired.", w.Name)
+	}
+
+	return nil
+}
+
+type WrappedSecretId struct {
+	SecretID         string          `json:"token"`
+	CreationTime     time.Time       `json:"creationTime"`
+	TTL              int             `json:"ttl"`
+	VaultAddr        string          `json:"vaultAddr"`
+	VaultCAs         []byte          `json:"vaultCAs"`
+	AppRoleMountPath string          `json:"appRoleMountPath"`
+	DeliveryType     string          `json:"deliveryType"`
+	Secrets          []WrappedSecret `json:"secrets"`
+
+	// Role is the AppRole or token role the token was created for, so that the init container can verify where the
+	// wrapping token was created before unwrapping it.
+	Role string `json:"role"`
+
+	// RoleID is only set if the controller is configured to supply role_ids.
+	RoleID string `json:"roleId"`
+
+	// AppRoles is set instead of SecretID when the delivery type is AppRolesDelivery.
+	AppRoles []WrappedAppRole `json:"appRoles"`
+
+	// PodNamespace, PodName and PodUID identify the pod the wrapped secret is intended for.
+	PodNamespace string `json:"podNamespace"`
+	PodName      string `json:"podName"`
+	PodUID       string `json:"podUID"`
+}
+
+func (w WrappedSecretId) Validate() error {
+
+	deliveryType := w.GetDeliveryType()
+
+	if deliveryType != SecretIdDelivery && deliveryType != TokenDelivery && deliveryType != AppRolesDelivery {
+		return errors.Errorf("Delivery type (%s) is not supported.", w.DeliveryType)
 	}
 
 	if w.VaultAddr == "" {
 		return errors.New("Vault server address is not set.")
 	}
 
-	if w.CreationTime.Add(time.Duration(w.TTL) * time.Second).Before(time.Now()) {
-		return errors.New("Token is expired.")
+	if deliveryType == AppRolesDelivery {
+
+		if len(w.AppRoles) == 0 {
+			return errors.New("No AppRoles were delivered.")
+		}
+
+		appRoleNames := map[string]bool{}
+
+		for _, appRole := range w.AppRoles {
+
+			if err := appRole.Validate(); err != nil {
+				return err
+			}
+
+			if appRoleNames[appRole.Name] {
+				return errors.Errorf("AppRole name (%s) is used more than once.", appRole.Name)
+			}
+
+			appRoleNames[appRole.Name] = true
+		}
+
+	} else {
+
+		if w.SecretID == "" {
+			return errors.New("Token is empty.")
+		}
+
+		if w.CreationTime.IsZero() {
+			return errors.New("CreationTime is invalid.")
+		}
+
+		if w.CreationTime.Add(time.Duration(w.TTL) * time.Second).Before(time.Now()) {
+			return errors.New("Token is expired.")
+		}
+	}
+
+	names := map[string]bool{}
+
+	for _, secret := range w.Secrets {
+
+		if err := secret.Validate(); err != nil {
+			return err
+		}
+
+		if names[secret.Name] {
+			return errors.Errorf("Secret name (%s) is used more than once.", secret.Name)
+		}
+
+		names[secret.Name] = true
+	}
+
+	return nil
+}
+
+// GetAppRoleMountPath returns the mount path of the AppRole backend the secret_id was issued from. Controllers that
+// predate configurable mount paths do not send one, so the default mount path is assumed.
+func (w WrappedSecretId) GetAppRoleMountPath() string {
+
+	if w.AppRoleMountPath == "" {
+		return DefaultAppRoleMountPath
+	}
+
+	return w.AppRoleMountPath
+}
+
+// GetDeliveryType returns whether the wrapped token contains an AppRole secret_id, a Vault token or a list of
+// secret_ids for several AppRoles. Controllers that predate token delivery do not send a delivery type, so a secret_id
+// is assumed.
+func (w WrappedSecretId) GetDeliveryType() string {
+
+	if w.DeliveryType == "" {
+		return SecretIdDelivery
+	}
+
+	return w.DeliveryType
+}
+
+// Expiration returns when the last of the wrapped tokens expires.
+func (w WrappedSecretId) Expiration() time.Time {
+
+	expiration := w.CreationTime.Add(time.Duration(w.TTL) * time.Second)
+
+	for _, appRole := range w.AppRoles {
+		if e := appRole.CreationTime.Add(time.Duration(appRole.TTL) * time.Second); e.After(expiration) {
+			expiration = e
+		}
+	}
+
+	for _, secret := range w.Secrets {
+		if e := secret.CreationTime.Add(time.Duration(secret.TTL) * time.Second); e.After(expiration) {
+			expiration = e
+		}
+	}
+
+	return expiration
+}
+
+// CheckPodIdentity returns an error if the wrapped secret was intended for a pod other than the one with the given
+// namespace, name and uid. Values that are empty on either side are not compared, so that controllers that predate
+// pod identities and init containers without the Downward API environment variables keep working. Such init containers
+// do not advertise CapabilityPodIdentity, so that the controller can warn about them.
+func (w WrappedSecretId) CheckPodIdentity(namespace string, name string, uid string) error {
+
+	mismatch := func(expected string, actual string) bool {
+		return expected != "" && actual != "" && expected != actual
+	}
+
+	if mismatch(w.PodNamespace, namespace) || mismatch(w.PodName, name) || mismatch(w.PodUID, uid) {
+		return errors.Errorf("Wrapped secret is intended for pod %s/%s (%s), not %s/%s (%s).", w.PodNamespace, w.PodName, w.PodUID, namespace, name, uid)
 	}
 
 	return nil
@@ -0,0 +1,82 @@
+package common
+
+import "testing"
+
+func TestCheckPodIdentity(t *testing.T) {
+
+	w := testWrappedSecretId()
+
+	tests := []struct {
+		name      string
+		payload   WrappedSecretId
+		namespace string
+		podName   string
+		uid       string
+		mismatch  bool
+	}{
+		{
+			name:      "match",
+			payload:   w,
+			namespace: w.PodNamespace,
+			podName:   w.PodName,
+			uid:       w.PodUID,
+		},
+		{
+			name:      "namespace mismatch",
+			payload:   w,
+			namespace: "other",
+			podName:   w.PodName,
+			uid:       w.PodUID,
+			mismatch:  true,
+		},
+		{
+			name:      "name mismatch",
+			payload:   w,
+			namespace: w.PodNamespace,
+			podName:   "sample-app-2",
+			uid:       w.PodUID,
+			mismatch:  true,
+		},
+		{
+			name:      "uid mismatch of a replaced pod",
+			payload:   w,
+			namespace: w.PodNamespace,
+			podName:   w.PodName,
+			uid:       "0c5e0d8b-94c2-11e7-8b3a-0800270a1f3c",
+			mismatch:  true,
+		},
+		{
+			name:    "init container without the Downward API variables",
+			payload: w,
+		},
+		{
+			name:      "init container with only some of the Downward API variables",
+			payload:   w,
+			namespace: w.PodNamespace,
+			podName:   "sample-app-2",
+			mismatch:  true,
+		},
+		{
+			name:      "controller that predates pod identities",
+			payload:   WrappedSecretId{SecretID: w.SecretID},
+			namespace: w.PodNamespace,
+			podName:   w.PodName,
+			uid:       w.PodUID,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := test.payload.CheckPodIdentity(test.namespace, test.podName, test.uid)
+
+			if test.mismatch && err == nil {
+				t.Error("expected a mismatch")
+			}
+
+			if !test.mismatch && err != nil {
+				t.Errorf("expected no mismatch, got: %s", err)
+			}
+		})
+	}
+}